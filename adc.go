@@ -8,6 +8,8 @@ import (
 var (
 	adcInitialized bool
 	adcPrefixDir   string
+	adcIIODir      string
+	adcMaxRaw      float32
 )
 
 type AInName string
@@ -24,14 +26,25 @@ const (
 
 func AInNameByPin(pinKey string) (AInName, bool) {
 	pin, ok := PinByKey(pinKey)
-	if !ok {
+	if !ok || pin.AInNr == -1 {
 		return "", false
 	}
 	return AInName(fmt.Sprintf("AIN%d", pin.AInNr)), true
 }
 
+// adcInit uses the in_voltageN_raw files of the TI-am335x-adc IIO driver
+// if available, else it falls back to the cape-bone-iio overlay
+// of 3.8 kernels.
 func adcInit() error {
-	err := LoadDeviceTree("cape-bone-iio")
+	dir, _, err := findADCIIODevice()
+	if err == nil {
+		adcIIODir = dir
+		adcMaxRaw = adcIIOMaxRaw
+		adcInitialized = true
+		return nil
+	}
+
+	err = LoadDeviceTree("cape-bone-iio")
 	if err != nil {
 		return err
 	}
@@ -39,14 +52,43 @@ func adcInit() error {
 	ocpDir, _ := BuildPath("/sys/devices", "ocp")
 	adcPrefixDir, _ = BuildPath(ocpDir, "helper")
 	adcPrefixDir += "/AIN"
+	adcMaxRaw = 1800 // millivolts
 	adcInitialized = true
 
 	return nil
 }
 
+func adcFilename(ain AInName) (string, error) {
+	channel, err := ainChannel(ain)
+	if err != nil {
+		return "", err
+	}
+	if adcIIODir != "" {
+		return fmt.Sprintf("%s/in_voltage%d_raw", adcIIODir, channel), nil
+	}
+	return adcPrefixDir + string(ain), nil
+}
+
+// ADC_REFERENCE_VOLTAGE is the full scale input voltage of the ADC.
+const ADC_REFERENCE_VOLTAGE = 1.8
+
+// adcIIOMaxRaw is the maximum raw value of the 12 bit TI-am335x-adc IIO driver.
+const adcIIOMaxRaw = 4095
+
+type ErrADC struct {
+	ain   AInName
+	cause error
+}
+
+func (errADC ErrADC) Error() string {
+	return fmt.Sprintf("ADC %s error: %s", errADC.ain, errADC.cause)
+}
+
 type ADC struct {
-	ain  AInName
-	file *os.File
+	ain         AInName
+	file        *os.File
+	calibration *ADCCalibration
+	filter      ADCFilter
 }
 
 func NewADC(ain AInName) (*ADC, error) {
@@ -57,27 +99,110 @@ func NewADC(ain AInName) (*ADC, error) {
 		}
 	}
 
-	filename := adcPrefixDir + string(ain)
+	filename, err := adcFilename(ain)
+	if err != nil {
+		return nil, err
+	}
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ADC{ain, file}, nil
+	return &ADC{ain: ain, file: file}, nil
 }
 
 func (adc *ADC) AIn() AInName {
 	return adc.ain
 }
 
+// Read returns the raw value of the ADC input.
+func (adc *ADC) Read() (value float32, err error) {
+	_, err = adc.file.Seek(0, os.SEEK_SET)
+	if err != nil {
+		return 0, ErrADC{adc.ain, err}
+	}
+	_, err = fmt.Fscan(adc.file, &value)
+	if err != nil {
+		return 0, ErrADC{adc.ain, err}
+	}
+	return value, nil
+}
+
+// ReadRaw returns the raw value of the ADC input
+// or zero in case of an error. Use Read to get the error.
 func (adc *ADC) ReadRaw() (value float32) {
-	adc.file.Seek(0, os.SEEK_SET)
-	fmt.Fscan(adc.file, &value)
+	value, _ = adc.Read()
 	return value
 }
 
+// ReadNormalized returns the value of the ADC input
+// in the range from 0.0 to 1.0.
+func (adc *ADC) ReadNormalized() (value float32, err error) {
+	value, err = adc.Read()
+	return value / adcMaxRaw, err
+}
+
+// ReadValue returns the value of the ADC input
+// in the range from 0.0 to 1.0 or zero in case of an error.
+// Use ReadNormalized to get the error.
 func (adc *ADC) ReadValue() (value float32) {
-	return adc.ReadRaw() / 1800.0
+	value, _ = adc.ReadNormalized()
+	return value
+}
+
+// ReadVolts returns the voltage of the ADC input
+// calculated from ADC_REFERENCE_VOLTAGE and the resolution of the ADC.
+func (adc *ADC) ReadVolts() (volts float32, err error) {
+	value, err := adc.ReadNormalized()
+	return value * ADC_REFERENCE_VOLTAGE, err
+}
+
+// ReadFiltered returns the voltage of the ADC input
+// passed through the filter of the ADC.
+// Without filter the result is the same as from ReadVolts.
+func (adc *ADC) ReadFiltered() (volts float32, err error) {
+	volts, err = adc.ReadVolts()
+	if err != nil || adc.filter == nil {
+		return volts, err
+	}
+	return adc.filter.Filter(volts), nil
+}
+
+// ReadCalibrated returns the voltage of the ADC input
+// with the filter and calibration of the ADC applied.
+// Without filter and calibration the result is the same as from ReadVolts.
+func (adc *ADC) ReadCalibrated() (value float32, err error) {
+	volts, err := adc.ReadFiltered()
+	if err != nil {
+		return 0, err
+	}
+	return adc.calibration.Apply(volts), nil
+}
+
+func (adc *ADC) Filter() ADCFilter {
+	return adc.filter
+}
+
+// SetFilter sets the filter used by ReadFiltered and ReadCalibrated.
+// nil disables filtering.
+func (adc *ADC) SetFilter(filter ADCFilter) {
+	adc.filter = filter
+}
+
+func (adc *ADC) Calibration() *ADCCalibration {
+	return adc.calibration
+}
+
+// SetCalibration sets the calibration used by ReadCalibrated.
+// nil disables calibration.
+// An invalid calibration table returns an error
+// and leaves the current calibration unchanged.
+func (adc *ADC) SetCalibration(calibration *ADCCalibration) error {
+	if err := calibration.validate(); err != nil {
+		return err
+	}
+	adc.calibration = calibration
+	return nil
 }
 
 func (adc *ADC) Close() error {
@@ -86,5 +211,9 @@ func (adc *ADC) Close() error {
 
 func CleanupADC() error {
 	adcInitialized = false
+	if adcIIODir != "" {
+		adcIIODir = ""
+		return nil
+	}
 	return UnloadDeviceTree("cape-bone-iio")
 }
@@ -0,0 +1,428 @@
+package bbio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ungerik/go-quick"
+)
+
+const (
+	iioDevicesDir = "/sys/bus/iio/devices"
+	adcIIOName    = "TI-am335x-adc"
+)
+
+// ErrADCSampleRateUnsupported is returned by ADCBuffer.SetSampleRate
+// if the IIO device has no sampling frequency attribute,
+// which is the case for the TI-am335x-adc driver.
+var ErrADCSampleRateUnsupported = errors.New("ADC IIO device does not support setting the sampling frequency")
+
+// findADCIIODevice returns the sysfs directory and the device number
+// of the IIO device of the TI-am335x-adc driver.
+func findADCIIODevice() (dir string, nr int, err error) {
+	dirFiles, err := ioutil.ReadDir(iioDevicesDir)
+	if err != nil {
+		return "", -1, err
+	}
+	for _, file := range dirFiles {
+		if !strings.HasPrefix(file.Name(), "iio:device") {
+			continue
+		}
+		dir = path.Join(iioDevicesDir, file.Name())
+		name, err := quick.FileGetString(dir + "/name")
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(name), adcIIOName) {
+			continue
+		}
+		_, err = fmt.Sscanf(file.Name(), "iio:device%d", &nr)
+		if err != nil {
+			return "", -1, err
+		}
+		return dir, nr, nil
+	}
+	return "", -1, os.ErrNotExist
+}
+
+// ainChannel returns the ADC input channel number of ain.
+func ainChannel(ain AInName) (int, error) {
+	var channel int
+	_, err := fmt.Sscanf(string(ain), "AIN%d", &channel)
+	if err != nil || channel < 0 || channel > 7 {
+		return -1, fmt.Errorf("Invalid ADC input name '%s'", ain)
+	}
+	return channel, nil
+}
+
+// iioScanElement describes the data layout of one channel
+// within a scan of an IIO buffer.
+type iioScanElement struct {
+	name        string
+	index       int
+	bigEndian   bool
+	signed      bool
+	bits        uint
+	storageBits uint
+	shift       uint
+	offset      int
+}
+
+// parseIIOScanType parses the content of a scan_elements/*_type file
+// like "le:u12/16>>0".
+func parseIIOScanType(element *iioScanElement, scanType string) error {
+	var endian string
+	var sign rune
+	_, err := fmt.Sscanf(strings.TrimSpace(scanType), "%2s:%c%d/%d>>%d", &endian, &sign, &element.bits, &element.storageBits, &element.shift)
+	if err != nil {
+		return fmt.Errorf("Can't parse IIO scan type '%s': %s", scanType, err)
+	}
+	if element.storageBits%8 != 0 || element.storageBits == 0 || element.storageBits > 64 {
+		return fmt.Errorf("Unsupported IIO scan storage bits %d", element.storageBits)
+	}
+	element.bigEndian = endian == "be"
+	element.signed = sign == 's'
+	return nil
+}
+
+func (element *iioScanElement) decode(scan []byte) int64 {
+	data := scan[element.offset : element.offset+int(element.storageBits/8)]
+	var value uint64
+	switch element.storageBits {
+	case 8:
+		value = uint64(data[0])
+	case 16:
+		if element.bigEndian {
+			value = uint64(binary.BigEndian.Uint16(data))
+		} else {
+			value = uint64(binary.LittleEndian.Uint16(data))
+		}
+	case 32:
+		if element.bigEndian {
+			value = uint64(binary.BigEndian.Uint32(data))
+		} else {
+			value = uint64(binary.LittleEndian.Uint32(data))
+		}
+	case 64:
+		if element.bigEndian {
+			value = binary.BigEndian.Uint64(data)
+		} else {
+			value = binary.LittleEndian.Uint64(data)
+		}
+	}
+	value >>= element.shift
+	if element.bits < 64 {
+		value &= 1<<element.bits - 1
+		if element.signed && value&(1<<(element.bits-1)) != 0 {
+			value |= ^uint64(0) << element.bits
+		}
+	}
+	return int64(value)
+}
+
+// ADCBufferSample holds one scan of all channels of an ADCBuffer.
+type ADCBufferSample struct {
+	// Time is the kernel timestamp of the scan,
+	// or the time it was read if the device has no timestamp channel.
+	Time time.Time
+	// Values holds the raw values in the order of ADCBuffer.AIns().
+	Values []float32
+}
+
+// ADCBuffer reads continuous samples of one or more ADC inputs
+// via the buffer of the TI-am335x-adc IIO device.
+// This allows much higher and more regular sample rates than ADC.ReadRaw.
+type ADCBuffer struct {
+	ains      []AInName
+	dir       string
+	file      *os.File
+	elements  []*iioScanElement // in order of ains
+	timestamp *iioScanElement   // nil if the device has no timestamp channel
+	scanSize  int
+
+	samplesOnce sync.Once
+	samples     chan ADCBufferSample
+	done        chan struct{}
+	closeOnce   sync.Once
+	errMutex    sync.Mutex
+	err         error
+}
+
+// NewADCBuffer enables the scan elements for ains plus the timestamp
+// if the device has one, sets the buffer length in scans
+// and the sampling rate in Hz, and starts the IIO buffer.
+// If sampleRate is zero, the sampling rate of the device is not changed.
+// Devices without a sampling frequency attribute like the TI-am335x-adc
+// ignore sampleRate, use SetSampleRate to detect this.
+func NewADCBuffer(length, sampleRate int, ains ...AInName) (*ADCBuffer, error) {
+	if len(ains) == 0 {
+		return nil, fmt.Errorf("No ADC inputs for ADCBuffer")
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("Invalid ADCBuffer length %d", length)
+	}
+	for i := range ains {
+		for j := i + 1; j < len(ains); j++ {
+			if ains[i] == ains[j] {
+				return nil, fmt.Errorf("ADC input %s used twice for ADCBuffer", ains[i])
+			}
+		}
+	}
+	dir, nr, err := findADCIIODevice()
+	if err != nil {
+		return nil, err
+	}
+	buf := &ADCBuffer{ains: ains, dir: dir, done: make(chan struct{})}
+
+	// Stop a buffer that might still be running from a previous user,
+	// scan elements can only be changed while the buffer is disabled
+	err = quick.FileSetString(dir+"/buffer/enable", "0")
+	if err != nil {
+		return nil, err
+	}
+
+	err = buf.enableScanElements()
+	if err != nil {
+		buf.disableScanElements()
+		return nil, err
+	}
+
+	if sampleRate > 0 {
+		err = buf.SetSampleRate(sampleRate)
+		if err != nil && err != ErrADCSampleRateUnsupported {
+			buf.disableScanElements()
+			return nil, err
+		}
+	}
+
+	err = quick.FileSetString(dir+"/buffer/length", fmt.Sprint(length))
+	if err != nil {
+		buf.disableScanElements()
+		return nil, err
+	}
+	err = quick.FileSetString(dir+"/buffer/enable", "1")
+	if err != nil {
+		buf.disableScanElements()
+		return nil, err
+	}
+
+	buf.file, err = os.Open(fmt.Sprintf("/dev/iio:device%d", nr))
+	if err != nil {
+		buf.stop()
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func (buf *ADCBuffer) enableScanElements() error {
+	scanDir := buf.dir + "/scan_elements/"
+	var elements []*iioScanElement
+
+	// Elements left enabled by someone else would change the scan layout
+	dirFiles, err := ioutil.ReadDir(scanDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range dirFiles {
+		if strings.HasSuffix(file.Name(), "_en") {
+			err = quick.FileSetString(scanDir+file.Name(), "0")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, ain := range buf.ains {
+		channel, err := ainChannel(ain)
+		if err != nil {
+			return err
+		}
+		element := &iioScanElement{name: fmt.Sprintf("in_voltage%d", channel)}
+		buf.elements = append(buf.elements, element)
+		elements = append(elements, element)
+	}
+	// The TI-am335x-adc driver has no timestamp channel
+	if _, err := os.Stat(scanDir + "in_timestamp_en"); err == nil {
+		buf.timestamp = &iioScanElement{name: "in_timestamp"}
+		elements = append(elements, buf.timestamp)
+	}
+
+	for _, element := range elements {
+		err := quick.FileSetString(scanDir+element.name+"_en", "1")
+		if err != nil {
+			return err
+		}
+		index, err := quick.FileGetString(scanDir + element.name + "_index")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Sscan(index, &element.index)
+		if err != nil {
+			return err
+		}
+		scanType, err := quick.FileGetString(scanDir + element.name + "_type")
+		if err != nil {
+			return err
+		}
+		err = parseIIOScanType(element, scanType)
+		if err != nil {
+			return err
+		}
+	}
+
+	buf.scanSize = layoutIIOScan(elements)
+	return nil
+}
+
+// layoutIIOScan sets the offsets of elements within a scan
+// and returns the size of the scan.
+// Elements are stored in the order of their index,
+// each one aligned to its own storage size.
+func layoutIIOScan(elements []*iioScanElement) int {
+	sort.Slice(elements, func(i, j int) bool { return elements[i].index < elements[j].index })
+	scanSize := 0
+	maxSize := 1
+	for _, element := range elements {
+		size := int(element.storageBits / 8)
+		if scanSize%size != 0 {
+			scanSize += size - scanSize%size
+		}
+		element.offset = scanSize
+		scanSize += size
+		if size > maxSize {
+			maxSize = size
+		}
+	}
+	if scanSize%maxSize != 0 {
+		scanSize += maxSize - scanSize%maxSize
+	}
+	return scanSize
+}
+
+func (buf *ADCBuffer) disableScanElements() {
+	scanDir := buf.dir + "/scan_elements/"
+	for _, element := range buf.elements {
+		quick.FileSetString(scanDir+element.name+"_en", "0")
+	}
+	if buf.timestamp != nil {
+		quick.FileSetString(scanDir+buf.timestamp.name+"_en", "0")
+	}
+}
+
+// SetSampleRate sets the sampling rate in Hz.
+// It returns ErrADCSampleRateUnsupported if the device
+// has no sampling frequency attribute.
+func (buf *ADCBuffer) SetSampleRate(sampleRate int) error {
+	for _, name := range []string{"in_voltage_sampling_frequency", "sampling_frequency"} {
+		filename := buf.dir + "/" + name
+		if _, err := os.Stat(filename); err == nil {
+			return quick.FileSetString(filename, fmt.Sprint(sampleRate))
+		}
+	}
+	return ErrADCSampleRateUnsupported
+}
+
+func (buf *ADCBuffer) stop() {
+	quick.FileSetString(buf.dir+"/buffer/enable", "0")
+	buf.disableScanElements()
+}
+
+// AIns returns the ADC inputs of the buffer
+// in the order of ADCBufferSample.Values.
+func (buf *ADCBuffer) AIns() []AInName {
+	return buf.ains
+}
+
+// ScanSize returns the size in bytes of one scan
+// as read by Read.
+func (buf *ADCBuffer) ScanSize() int {
+	return buf.scanSize
+}
+
+// Read implements io.Reader by reading raw scan data
+// from the IIO character device.
+// len(p) should be a multiple of ScanSize().
+func (buf *ADCBuffer) Read(p []byte) (n int, err error) {
+	return buf.file.Read(p)
+}
+
+// ReadSample blocks until the next scan is available and returns it.
+func (buf *ADCBuffer) ReadSample() (sample ADCBufferSample, err error) {
+	scan := make([]byte, buf.scanSize)
+	_, err = io.ReadFull(buf.file, scan)
+	if err != nil {
+		return sample, err
+	}
+	return buf.decodeScan(scan), nil
+}
+
+func (buf *ADCBuffer) decodeScan(scan []byte) ADCBufferSample {
+	sample := ADCBufferSample{Values: make([]float32, len(buf.elements))}
+	if buf.timestamp != nil {
+		sample.Time = time.Unix(0, buf.timestamp.decode(scan))
+	} else {
+		sample.Time = time.Now()
+	}
+	for i, element := range buf.elements {
+		sample.Values[i] = float32(element.decode(scan))
+	}
+	return sample
+}
+
+// Samples returns a channel that receives all scans of the buffer.
+// The channel will be closed when the buffer is closed
+// or a read error happens, see Err.
+func (buf *ADCBuffer) Samples() chan ADCBufferSample {
+	buf.samplesOnce.Do(func() {
+		buf.samples = make(chan ADCBufferSample, 64)
+		go func() {
+			defer close(buf.samples)
+			for {
+				sample, err := buf.ReadSample()
+				if err != nil {
+					select {
+					case <-buf.done:
+						// Read error caused by Close
+					default:
+						buf.setErr(err)
+					}
+					return
+				}
+				select {
+				case buf.samples <- sample:
+				case <-buf.done:
+					return
+				}
+			}
+		}()
+	})
+	return buf.samples
+}
+
+func (buf *ADCBuffer) setErr(err error) {
+	buf.errMutex.Lock()
+	defer buf.errMutex.Unlock()
+	buf.err = err
+}
+
+// Err returns the error that caused the channel
+// returned by Samples to be closed.
+func (buf *ADCBuffer) Err() error {
+	buf.errMutex.Lock()
+	defer buf.errMutex.Unlock()
+	return buf.err
+}
+
+// Close stops the IIO buffer and disables the scan elements.
+func (buf *ADCBuffer) Close() error {
+	buf.closeOnce.Do(func() { close(buf.done) })
+	buf.stop()
+	return buf.file.Close()
+}
@@ -0,0 +1,118 @@
+package bbio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseIIOScanType(t *testing.T) {
+	tests := []struct {
+		scanType    string
+		bigEndian   bool
+		signed      bool
+		bits        uint
+		storageBits uint
+		shift       uint
+	}{
+		{"le:u12/16>>0\n", false, false, 12, 16, 0},
+		{"be:s14/16>>2", true, true, 14, 16, 2},
+		{"le:s64/64>>0", false, true, 64, 64, 0},
+	}
+	for _, test := range tests {
+		var element iioScanElement
+		err := parseIIOScanType(&element, test.scanType)
+		if err != nil {
+			t.Errorf("%q: %s", test.scanType, err)
+			continue
+		}
+		if element.bigEndian != test.bigEndian || element.signed != test.signed ||
+			element.bits != test.bits || element.storageBits != test.storageBits || element.shift != test.shift {
+			t.Errorf("%q: parsed as %+v", test.scanType, element)
+		}
+	}
+
+	for _, scanType := range []string{"", "le:u12", "le:u12/12>>0", "le:u12/0>>0", "le:u64/128>>0"} {
+		var element iioScanElement
+		if parseIIOScanType(&element, scanType) == nil {
+			t.Errorf("%q: expected error", scanType)
+		}
+	}
+}
+
+func TestIIOScanElementDecode(t *testing.T) {
+	tests := []struct {
+		scanType string
+		offset   int
+		scan     []byte
+		value    int64
+	}{
+		{"le:u12/16>>0", 0, []byte{0xFF, 0xFF}, 0xFFF},
+		{"le:u12/16>>0", 2, []byte{0, 0, 0x34, 0x12}, 0x234},
+		{"be:u16/16>>0", 0, []byte{0x12, 0x34}, 0x1234},
+		{"le:s12/16>>4", 0, []byte{0xF0, 0xFF}, -1},
+		{"le:s8/8>>0", 0, []byte{0x80}, -128},
+		{"be:u24/32>>8", 0, []byte{0x01, 0x02, 0x03, 0x04}, 0x010203},
+		{"le:s64/64>>0", 0, []byte{0xFE, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, -2},
+	}
+	for _, test := range tests {
+		element := iioScanElement{offset: test.offset}
+		err := parseIIOScanType(&element, test.scanType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value := element.decode(test.scan); value != test.value {
+			t.Errorf("%s % X: decoded %d instead of %d", test.scanType, test.scan, value, test.value)
+		}
+	}
+}
+
+func TestLayoutIIOScan(t *testing.T) {
+	ain1 := &iioScanElement{index: 1, storageBits: 16}
+	ain0 := &iioScanElement{index: 0, storageBits: 16}
+	ain4 := &iioScanElement{index: 4, storageBits: 16}
+	timestamp := &iioScanElement{index: 8, storageBits: 64}
+
+	size := layoutIIOScan([]*iioScanElement{ain4, timestamp, ain1, ain0})
+	if size != 16 {
+		t.Errorf("scan size %d instead of 16", size)
+	}
+	for _, test := range []struct {
+		element *iioScanElement
+		offset  int
+	}{{ain0, 0}, {ain1, 2}, {ain4, 4}, {timestamp, 8}} {
+		if test.element.offset != test.offset {
+			t.Errorf("element %d has offset %d instead of %d", test.element.index, test.element.offset, test.offset)
+		}
+	}
+
+	// Without timestamp, like the TI-am335x-adc
+	size = layoutIIOScan([]*iioScanElement{ain1, ain0, ain4})
+	if size != 6 {
+		t.Errorf("scan size %d instead of 6", size)
+	}
+}
+
+func TestADCBufferDecodeScan(t *testing.T) {
+	ain0 := &iioScanElement{index: 0, bits: 12, storageBits: 16}
+	ain2 := &iioScanElement{index: 2, bits: 12, storageBits: 16}
+	buf := &ADCBuffer{elements: []*iioScanElement{ain2, ain0}}
+	buf.scanSize = layoutIIOScan([]*iioScanElement{ain2, ain0})
+
+	before := time.Now()
+	sample := buf.decodeScan([]byte{0x01, 0x00, 0xFF, 0x0F})
+	if sample.Time.Before(before) {
+		t.Errorf("sample time %s is not the read time", sample.Time)
+	}
+	if len(sample.Values) != 2 || sample.Values[0] != 0xFFF || sample.Values[1] != 1 {
+		t.Errorf("decoded values %v", sample.Values)
+	}
+
+	timestamp := &iioScanElement{index: 3, bits: 64, storageBits: 64, signed: true}
+	buf.timestamp = timestamp
+	buf.scanSize = layoutIIOScan([]*iioScanElement{ain2, ain0, timestamp})
+	scan := []byte{0x01, 0x00, 0xFF, 0x0F, 0, 0, 0, 0, 0x00, 0xCA, 0x9A, 0x3B, 0, 0, 0, 0}
+	sample = buf.decodeScan(scan)
+	if !sample.Time.Equal(time.Unix(1, 0)) {
+		t.Errorf("sample time %s instead of kernel timestamp", sample.Time)
+	}
+}
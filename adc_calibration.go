@@ -0,0 +1,129 @@
+package bbio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// ADCCalibrationPoint maps a measured voltage to the calibrated value.
+type ADCCalibrationPoint struct {
+	Measured   float32 `json:"measured"`
+	Calibrated float32 `json:"calibrated"`
+}
+
+// ADCCalibration converts measured voltages to calibrated values.
+// If Table is not empty, the measured voltage is mapped by linear
+// interpolation between the table points first.
+// Then the polynomial is applied, if Polynomial is not empty:
+//
+//	value = Polynomial[0] + Polynomial[1]*v + Polynomial[2]*v*v + ...
+//
+// A nil *ADCCalibration returns the measured voltage unchanged.
+type ADCCalibration struct {
+	Table      []ADCCalibrationPoint `json:"table,omitempty"`
+	Polynomial []float32             `json:"polynomial,omitempty"`
+}
+
+// NewLinearADCCalibration returns a calibration
+// that calculates offset + gain*volts.
+func NewLinearADCCalibration(offset, gain float32) *ADCCalibration {
+	return &ADCCalibration{Polynomial: []float32{offset, gain}}
+}
+
+// NewTableADCCalibration returns a calibration that interpolates
+// linearly between the points of table.
+func NewTableADCCalibration(table []ADCCalibrationPoint) (*ADCCalibration, error) {
+	cal := &ADCCalibration{Table: table}
+	return cal, cal.validate()
+}
+
+func (cal *ADCCalibration) validate() error {
+	if cal == nil {
+		return nil
+	}
+	if len(cal.Table) == 1 {
+		return fmt.Errorf("ADC calibration table needs at least two points")
+	}
+	// Sort a copy, the table may still be used by the caller
+	table := make([]ADCCalibrationPoint, len(cal.Table))
+	copy(table, cal.Table)
+	sort.Slice(table, func(i, j int) bool { return table[i].Measured < table[j].Measured })
+	cal.Table = table
+	for i := 1; i < len(cal.Table); i++ {
+		if cal.Table[i].Measured == cal.Table[i-1].Measured {
+			return fmt.Errorf("ADC calibration table has two points for %f", cal.Table[i].Measured)
+		}
+	}
+	return nil
+}
+
+// Apply returns the calibrated value for volts.
+func (cal *ADCCalibration) Apply(volts float32) float32 {
+	if cal == nil {
+		return volts
+	}
+	value := volts
+	if len(cal.Table) > 1 {
+		value = cal.interpolate(value)
+	}
+	if len(cal.Polynomial) > 0 {
+		result := float32(0)
+		for i := len(cal.Polynomial) - 1; i >= 0; i-- {
+			result = result*value + cal.Polynomial[i]
+		}
+		value = result
+	}
+	return value
+}
+
+// interpolate extrapolates linearly beyond the first and last table point.
+func (cal *ADCCalibration) interpolate(volts float32) float32 {
+	i := sort.Search(len(cal.Table), func(i int) bool { return cal.Table[i].Measured >= volts })
+	if i == 0 {
+		i = 1
+	} else if i == len(cal.Table) {
+		i = len(cal.Table) - 1
+	}
+	p0, p1 := cal.Table[i-1], cal.Table[i]
+	return p0.Calibrated + (volts-p0.Measured)*(p1.Calibrated-p0.Calibrated)/(p1.Measured-p0.Measured)
+}
+
+// LoadADCCalibrations loads calibrations per ADC input from a JSON file
+// of the form:
+//
+//	{
+//	  "AIN0": {"polynomial": [0.01, 1.02]},
+//	  "AIN1": {"table": [{"measured": 0.1, "calibrated": 0.12}, {"measured": 1.7, "calibrated": 1.75}]}
+//	}
+func LoadADCCalibrations(filename string) (map[AInName]*ADCCalibration, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var calibrations map[AInName]*ADCCalibration
+	err = json.Unmarshal(data, &calibrations)
+	if err != nil {
+		return nil, err
+	}
+	for ain, cal := range calibrations {
+		if _, err = ainChannel(ain); err != nil {
+			return nil, err
+		}
+		if err = cal.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", ain, err)
+		}
+	}
+	return calibrations, nil
+}
+
+// SaveADCCalibrations saves calibrations per ADC input as JSON file
+// in the format read by LoadADCCalibrations.
+func SaveADCCalibrations(filename string, calibrations map[AInName]*ADCCalibration) error {
+	data, err := json.MarshalIndent(calibrations, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
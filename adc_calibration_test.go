@@ -0,0 +1,119 @@
+package bbio
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func approxEqual(a, b float32) bool {
+	return math.Abs(float64(a-b)) < 1e-5
+}
+
+func TestADCCalibrationApply(t *testing.T) {
+	var nilCal *ADCCalibration
+	if nilCal.Apply(1.25) != 1.25 {
+		t.Error("nil calibration changed the value")
+	}
+
+	linear := NewLinearADCCalibration(0.1, 2)
+	if v := linear.Apply(0.5); !approxEqual(v, 1.1) {
+		t.Errorf("linear calibration of 0.5 is %f instead of 1.1", v)
+	}
+
+	cal := &ADCCalibration{Polynomial: []float32{1, 0, 3}}
+	if v := cal.Apply(2); !approxEqual(v, 13) {
+		t.Errorf("polynomial calibration of 2 is %f instead of 13", v)
+	}
+}
+
+func TestTableADCCalibration(t *testing.T) {
+	table := []ADCCalibrationPoint{{1, 10}, {0, 0}, {1.5, 20}}
+	cal, err := NewTableADCCalibration(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table[0].Measured != 1 || table[1].Measured != 0 {
+		t.Error("caller's table was sorted")
+	}
+	tests := []struct{ volts, value float32 }{
+		{0, 0},
+		{0.5, 5},
+		{1, 10},
+		{1.25, 15},
+		{-0.1, -1}, // extrapolated below
+		{1.6, 22},  // extrapolated above
+	}
+	for _, test := range tests {
+		if v := cal.Apply(test.volts); !approxEqual(v, test.value) {
+			t.Errorf("table calibration of %f is %f instead of %f", test.volts, v, test.value)
+		}
+	}
+
+	_, err = NewTableADCCalibration([]ADCCalibrationPoint{{1, 1}})
+	if err == nil {
+		t.Error("expected error for single point table")
+	}
+	_, err = NewTableADCCalibration([]ADCCalibrationPoint{{1, 1}, {1, 2}})
+	if err == nil {
+		t.Error("expected error for duplicate measured value")
+	}
+}
+
+func TestSaveLoadADCCalibrations(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "calibration.json")
+	calibrations := map[AInName]*ADCCalibration{
+		"AIN0": NewLinearADCCalibration(0.01, 1.02),
+		"AIN1": {Table: []ADCCalibrationPoint{{1.7, 1.75}, {0.1, 0.12}}},
+	}
+	err := SaveADCCalibrations(filename, calibrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadADCCalibrations(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || len(loaded["AIN0"].Polynomial) != 2 || len(loaded["AIN1"].Table) != 2 {
+		t.Fatalf("loaded %+v", loaded)
+	}
+	if loaded["AIN1"].Table[0].Measured != 0.1 {
+		t.Error("loaded table is not sorted")
+	}
+
+	err = SaveADCCalibrations(filename, map[AInName]*ADCCalibration{"AIN9": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadADCCalibrations(filename)
+	if err == nil {
+		t.Error("expected error for invalid ADC input name")
+	}
+}
+
+func TestADCSetCalibration(t *testing.T) {
+	adc := &ADC{}
+	linear := NewLinearADCCalibration(0, 2)
+	if err := adc.SetCalibration(linear); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range [][]ADCCalibrationPoint{{{1, 1}}, {{1, 1}, {1, 2}}} {
+		if adc.SetCalibration(&ADCCalibration{Table: table}) == nil {
+			t.Errorf("expected error for table %v", table)
+		}
+	}
+	if adc.Calibration() != linear {
+		t.Error("invalid calibration replaced the current one")
+	}
+
+	cal := &ADCCalibration{Table: []ADCCalibrationPoint{{1, 10}, {0, 0}}}
+	if err := adc.SetCalibration(cal); err != nil {
+		t.Fatal(err)
+	}
+	if v := adc.Calibration().Apply(0.5); !approxEqual(v, 5) {
+		t.Errorf("calibration of unsorted table returned %f instead of 5", v)
+	}
+	if err := adc.SetCalibration(nil); err != nil || adc.Calibration() != nil {
+		t.Errorf("SetCalibration(nil) returned %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package bbio
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ADCFilter filters a stream of ADC values.
+// Use ADC.SetFilter to configure the filter of an ADC input.
+type ADCFilter interface {
+	// Filter adds value to the filter and returns the filtered value.
+	Filter(value float32) float32
+	// Reset clears the history of the filter.
+	Reset()
+}
+
+// ReadAveraged reads n raw values and returns their average.
+func (adc *ADC) ReadAveraged(n int) (value float32, err error) {
+	if n < 1 {
+		return 0, fmt.Errorf("Invalid number of ADC samples %d", n)
+	}
+	var sum float32
+	for i := 0; i < n; i++ {
+		value, err = adc.Read()
+		if err != nil {
+			return 0, err
+		}
+		sum += value
+	}
+	return sum / float32(n), nil
+}
+
+// sampleWindow is a ring buffer of the last len(values) samples.
+type sampleWindow struct {
+	values []float32
+	next   int
+	count  int
+}
+
+func (w *sampleWindow) add(value float32) {
+	w.values[w.next] = value
+	w.next = (w.next + 1) % len(w.values)
+	if w.count < len(w.values) {
+		w.count++
+	}
+}
+
+func (w *sampleWindow) reset() {
+	w.next = 0
+	w.count = 0
+}
+
+func newSampleWindow(n int) sampleWindow {
+	if n < 1 {
+		n = 1
+	}
+	return sampleWindow{values: make([]float32, n)}
+}
+
+// AverageFilter returns the average of the last N values.
+type AverageFilter struct {
+	window sampleWindow
+	sum    float64
+}
+
+// NewAverageFilter returns a filter averaging n values,
+// n less than 1 is treated as 1.
+func NewAverageFilter(n int) *AverageFilter {
+	return &AverageFilter{window: newSampleWindow(n)}
+}
+
+func (f *AverageFilter) Filter(value float32) float32 {
+	if f.window.count == len(f.window.values) {
+		f.sum -= float64(f.window.values[f.window.next])
+	}
+	f.window.add(value)
+	if f.window.next == 0 {
+		// Recalculate once per window to avoid drifting rounding errors
+		f.sum = 0
+		for _, v := range f.window.values[:f.window.count] {
+			f.sum += float64(v)
+		}
+	} else {
+		f.sum += float64(value)
+	}
+	return float32(f.sum / float64(f.window.count))
+}
+
+func (f *AverageFilter) Reset() {
+	f.window.reset()
+	f.sum = 0
+}
+
+// MedianFilter returns the median of the last N values.
+// It removes single spikes without smearing them like AverageFilter.
+type MedianFilter struct {
+	window sampleWindow
+	sorted []float32
+}
+
+// NewMedianFilter returns a filter using the median of n values,
+// n less than 1 is treated as 1.
+func NewMedianFilter(n int) *MedianFilter {
+	window := newSampleWindow(n)
+	return &MedianFilter{
+		window: window,
+		sorted: make([]float32, 0, len(window.values)),
+	}
+}
+
+func (f *MedianFilter) Filter(value float32) float32 {
+	f.window.add(value)
+	f.sorted = append(f.sorted[:0], f.window.values[:f.window.count]...)
+	sort.Slice(f.sorted, func(i, j int) bool { return f.sorted[i] < f.sorted[j] })
+	middle := len(f.sorted) / 2
+	if len(f.sorted)%2 == 0 {
+		return (f.sorted[middle-1] + f.sorted[middle]) / 2
+	}
+	return f.sorted[middle]
+}
+
+func (f *MedianFilter) Reset() {
+	f.window.reset()
+}
+
+// EMAFilter is an exponential moving average filter.
+// Alpha in the range from 0.0 to 1.0 is the weight of a new value,
+// smaller values result in stronger smoothing.
+type EMAFilter struct {
+	Alpha  float32
+	value  float32
+	primed bool
+}
+
+// NewEMAFilter returns an EMAFilter with alpha,
+// or an error if alpha is not greater than 0.0 and at most 1.0.
+func NewEMAFilter(alpha float32) (*EMAFilter, error) {
+	if !(alpha > 0 && alpha <= 1) {
+		return nil, fmt.Errorf("Invalid EMA filter alpha %f", alpha)
+	}
+	return &EMAFilter{Alpha: alpha}, nil
+}
+
+func (f *EMAFilter) Filter(value float32) float32 {
+	if !f.primed {
+		f.value = value
+		f.primed = true
+	} else {
+		f.value += f.Alpha * (value - f.value)
+	}
+	return f.value
+}
+
+func (f *EMAFilter) Reset() {
+	f.primed = false
+}
+
+// FIRFilter is a finite impulse response filter
+// with arbitrary coefficients.
+type FIRFilter struct {
+	coefficients []float32
+	window       sampleWindow
+}
+
+// NewFIRFilter returns a filter with coefficients,
+// the first one is applied to the newest value.
+// Without coefficients the filter passes values unchanged.
+func NewFIRFilter(coefficients []float32) *FIRFilter {
+	if len(coefficients) == 0 {
+		coefficients = []float32{1}
+	}
+	return &FIRFilter{
+		coefficients: coefficients,
+		window:       sampleWindow{values: make([]float32, len(coefficients))},
+	}
+}
+
+// NewLowPassFIRFilter returns a windowed sinc low-pass filter
+// with taps coefficients for the cutoff frequency and sample rate in Hz.
+// taps less than 1 is treated as 1.
+// An error is returned if cutoff is not between 0 and half the sample rate.
+func NewLowPassFIRFilter(cutoff, sampleRate float64, taps int) (*FIRFilter, error) {
+	if err := checkLowPassCutoff(cutoff, sampleRate); err != nil {
+		return nil, err
+	}
+	if taps < 1 {
+		taps = 1
+	}
+	coefficients := make([]float32, taps)
+	fc := cutoff / sampleRate
+	middle := float64(taps-1) / 2
+	var sum float64
+	for i := range coefficients {
+		x := float64(i) - middle
+		h := 2 * fc
+		if x != 0 {
+			h = math.Sin(2*math.Pi*fc*x) / (math.Pi * x)
+		}
+		if taps > 1 {
+			// Hamming window
+			h *= 0.54 - 0.46*math.Cos(2*math.Pi*float64(i)/float64(taps-1))
+		}
+		coefficients[i] = float32(h)
+		sum += h
+	}
+	// Normalize for unity gain at DC
+	for i := range coefficients {
+		coefficients[i] /= float32(sum)
+	}
+	return NewFIRFilter(coefficients), nil
+}
+
+// checkLowPassCutoff checks that cutoff is above 0 Hz
+// and below the Nyquist frequency of sampleRate.
+func checkLowPassCutoff(cutoff, sampleRate float64) error {
+	if !(sampleRate > 0) || math.IsInf(sampleRate, 0) {
+		return fmt.Errorf("Invalid filter sample rate %f Hz", sampleRate)
+	}
+	if !(cutoff > 0 && cutoff < sampleRate/2) {
+		return fmt.Errorf("Filter cutoff %f Hz not between 0 and %f Hz", cutoff, sampleRate/2)
+	}
+	return nil
+}
+
+func (f *FIRFilter) Filter(value float32) float32 {
+	if f.window.count == 0 {
+		// Prime with the first value to avoid a slow rise from zero
+		for i := 1; i < len(f.window.values); i++ {
+			f.window.add(value)
+		}
+	}
+	f.window.add(value)
+	// window.next is the oldest value
+	var result float32
+	n := len(f.window.values)
+	for i, c := range f.coefficients {
+		result += c * f.window.values[(f.window.next+n-1-i)%n]
+	}
+	return result
+}
+
+func (f *FIRFilter) Reset() {
+	f.window.reset()
+}
+
+// BiquadFilter is a second order infinite impulse response filter.
+type BiquadFilter struct {
+	b0, b1, b2, a1, a2 float32
+	x1, x2, y1, y2     float32
+	primed             bool
+}
+
+// NewLowPassBiquadFilter returns a Butterworth low-pass filter
+// for the cutoff frequency and sample rate in Hz.
+// An error is returned if cutoff is not between 0 and half the sample rate.
+func NewLowPassBiquadFilter(cutoff, sampleRate float64) (*BiquadFilter, error) {
+	if err := checkLowPassCutoff(cutoff, sampleRate); err != nil {
+		return nil, err
+	}
+	w0 := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w0) / math.Sqrt2 // sin(w0) / (2*Q) with Q = 1/sqrt(2)
+	cosW0 := math.Cos(w0)
+	a0 := 1 + alpha
+	return &BiquadFilter{
+		b0: float32((1 - cosW0) / 2 / a0),
+		b1: float32((1 - cosW0) / a0),
+		b2: float32((1 - cosW0) / 2 / a0),
+		a1: float32(-2 * cosW0 / a0),
+		a2: float32((1 - alpha) / a0),
+	}, nil
+}
+
+func (f *BiquadFilter) Filter(value float32) float32 {
+	if !f.primed {
+		// Start in the steady state of value
+		f.x1, f.x2, f.y1, f.y2 = value, value, value, value
+		f.primed = true
+	}
+	y := f.b0*value + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, value
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+func (f *BiquadFilter) Reset() {
+	f.primed = false
+}
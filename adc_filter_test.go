@@ -0,0 +1,143 @@
+package bbio
+
+import (
+	"math"
+	"testing"
+)
+
+func filterAll(filter ADCFilter, values ...float32) []float32 {
+	results := make([]float32, len(values))
+	for i, value := range values {
+		results[i] = filter.Filter(value)
+	}
+	return results
+}
+
+func checkFiltered(t *testing.T, name string, results, expected []float32) {
+	t.Helper()
+	for i := range expected {
+		if !approxEqual(results[i], expected[i]) {
+			t.Errorf("%s: results %v instead of %v", name, results, expected)
+			return
+		}
+	}
+}
+
+func TestAverageFilter(t *testing.T) {
+	f := NewAverageFilter(3)
+	checkFiltered(t, "average", filterAll(f, 3, 6, 9, 12, 0), []float32{3, 4.5, 6, 9, 7})
+	f.Reset()
+	checkFiltered(t, "average after reset", filterAll(f, 1, 2), []float32{1, 1.5})
+
+	// Alternating large and small values must not accumulate rounding errors
+	f = NewAverageFilter(4)
+	for i := 0; i < 100000; i++ {
+		f.Filter(1e6)
+		f.Filter(0.1)
+	}
+	results := filterAll(f, 1, 1, 1, 1)
+	checkFiltered(t, "average after drift", results[3:], []float32{1})
+}
+
+func TestMedianFilter(t *testing.T) {
+	f := NewMedianFilter(3)
+	checkFiltered(t, "median", filterAll(f, 1, 100, 2, 3, 4), []float32{1, 50.5, 2, 3, 3})
+	f.Reset()
+	checkFiltered(t, "median after reset", filterAll(f, 7), []float32{7})
+}
+
+func TestEMAFilter(t *testing.T) {
+	f, err := NewEMAFilter(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiltered(t, "EMA", filterAll(f, 4, 0, 0), []float32{4, 2, 1})
+	f.Reset()
+	checkFiltered(t, "EMA after reset", filterAll(f, 8), []float32{8})
+}
+
+func TestFIRFilter(t *testing.T) {
+	f := NewFIRFilter([]float32{0.5, 0.25, 0.25})
+	// Primed with the first value
+	checkFiltered(t, "FIR", filterAll(f, 4, 8, 0, 0), []float32{4, 6, 3, 2})
+
+	lowPass, err := NewLowPassFIRFilter(10, 1000, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum float32
+	for _, c := range lowPass.coefficients {
+		sum += c
+	}
+	if !approxEqual(sum, 1) {
+		t.Errorf("low-pass coefficients sum up to %f instead of 1", sum)
+	}
+	checkFiltered(t, "low-pass DC", filterAll(lowPass, 2, 2, 2), []float32{2, 2, 2})
+}
+
+func TestBiquadFilter(t *testing.T) {
+	f, err := NewLowPassBiquadFilter(10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiltered(t, "biquad DC", filterAll(f, 3, 3, 3), []float32{3, 3, 3})
+
+	// A signal far above the cutoff is attenuated
+	f.Reset()
+	var peak float64
+	for i := 0; i < 1000; i++ {
+		value := f.Filter(float32(math.Sin(2 * math.Pi * 400 * float64(i) / 1000)))
+		if i > 500 && math.Abs(float64(value)) > peak {
+			peak = math.Abs(float64(value))
+		}
+	}
+	if peak > 0.01 {
+		t.Errorf("400 Hz peak after 10 Hz low-pass is %f", peak)
+	}
+}
+
+func TestFilterInvalidSize(t *testing.T) {
+	lowPass, err := NewLowPassFIRFilter(10, 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := map[string]ADCFilter{
+		"average":  NewAverageFilter(0),
+		"median":   NewMedianFilter(-1),
+		"FIR":      NewFIRFilter(nil),
+		"low-pass": lowPass,
+	}
+	for name, f := range filters {
+		checkFiltered(t, name, filterAll(f, 1, 2), []float32{1, 2})
+	}
+}
+
+func TestFilterInvalidParameters(t *testing.T) {
+	nan := math.NaN()
+	for _, alpha := range []float32{0, -0.5, 1.5, float32(nan)} {
+		if _, err := NewEMAFilter(alpha); err == nil {
+			t.Errorf("expected error for EMA alpha %f", alpha)
+		}
+	}
+	if _, err := NewEMAFilter(1); err != nil {
+		t.Errorf("EMA alpha 1 returned %s", err)
+	}
+	tests := []struct{ cutoff, sampleRate float64 }{
+		{nan, 1000},
+		{0, 1000},
+		{-10, 1000},
+		{500, 1000},
+		{600, 1000},
+		{10, 0},
+		{10, nan},
+		{10, math.Inf(1)},
+	}
+	for _, test := range tests {
+		if _, err := NewLowPassFIRFilter(test.cutoff, test.sampleRate, 15); err == nil {
+			t.Errorf("expected FIR error for cutoff %f at %f Hz", test.cutoff, test.sampleRate)
+		}
+		if _, err := NewLowPassBiquadFilter(test.cutoff, test.sampleRate); err == nil {
+			t.Errorf("expected biquad error for cutoff %f at %f Hz", test.cutoff, test.sampleRate)
+		}
+	}
+}
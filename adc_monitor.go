@@ -0,0 +1,314 @@
+package bbio
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type ADCEventType int
+
+const (
+	// ADC_EVENT_ABOVE is fired when the value rises above ADCAlarm.High.
+	ADC_EVENT_ABOVE ADCEventType = iota
+	// ADC_EVENT_BELOW is fired when the value falls below ADCAlarm.Low.
+	ADC_EVENT_BELOW
+	// ADC_EVENT_INSIDE is fired when the value returns into the window
+	// between ADCAlarm.Low and ADCAlarm.High, including the hysteresis.
+	ADC_EVENT_INSIDE
+	// ADC_EVENT_ERROR is fired when the ADC input could not be read.
+	// The first value read after an error fires the current state once.
+	ADC_EVENT_ERROR
+)
+
+func (t ADCEventType) String() string {
+	switch t {
+	case ADC_EVENT_ABOVE:
+		return "above"
+	case ADC_EVENT_BELOW:
+		return "below"
+	case ADC_EVENT_INSIDE:
+		return "inside"
+	case ADC_EVENT_ERROR:
+		return "error"
+	}
+	return fmt.Sprintf("ADCEventType(%d)", int(t))
+}
+
+type ADCEvent struct {
+	AIn   AInName
+	Type  ADCEventType
+	Value float32
+	Time  time.Time
+	Err   error
+}
+
+// ADCAlarm configures the monitoring of an ADC input.
+// Values are in volts after Filter and Calibration have been applied.
+// Use math.Inf(1) for High or math.Inf(-1) for Low
+// to monitor only a single threshold.
+type ADCAlarm struct {
+	Low        float32
+	High       float32
+	Hysteresis float32
+
+	// Filter is optional.
+	Filter ADCFilter
+	// Calibration is optional.
+	Calibration *ADCCalibration
+	// OnEvent is optional and called from the goroutine of the monitor.
+	OnEvent func(ADCEvent)
+}
+
+// NewADCAlarmAbove returns an ADCAlarm that fires
+// ADC_EVENT_ABOVE when the value rises above threshold
+// and ADC_EVENT_INSIDE when it falls below threshold-hysteresis.
+func NewADCAlarmAbove(threshold, hysteresis float32, onEvent func(ADCEvent)) ADCAlarm {
+	return ADCAlarm{
+		Low:        float32(math.Inf(-1)),
+		High:       threshold,
+		Hysteresis: hysteresis,
+		OnEvent:    onEvent,
+	}
+}
+
+// NewADCAlarmBelow returns an ADCAlarm that fires
+// ADC_EVENT_BELOW when the value falls below threshold
+// and ADC_EVENT_INSIDE when it rises above threshold+hysteresis.
+func NewADCAlarmBelow(threshold, hysteresis float32, onEvent func(ADCEvent)) ADCAlarm {
+	return ADCAlarm{
+		Low:        threshold,
+		High:       float32(math.Inf(1)),
+		Hysteresis: hysteresis,
+		OnEvent:    onEvent,
+	}
+}
+
+type adcMonitorChannel struct {
+	ain    AInName
+	alarm  ADCAlarm
+	adc    *ADC
+	state  ADCEventType // ADC_EVENT_ERROR until the first value
+	failed bool         // the last read returned an error
+}
+
+// ADCMonitor watches ADC inputs for values
+// crossing thresholds or leaving a window.
+type ADCMonitor struct {
+	channels []*adcMonitorChannel
+	events   chan ADCEvent
+	buffer   *ADCBuffer
+	stop     chan struct{}
+	done     sync.WaitGroup
+}
+
+func NewADCMonitor() *ADCMonitor {
+	return &ADCMonitor{}
+}
+
+// Watch adds the ADC input ain with alarm to the monitor.
+// It must be called before Start or StartBuffered.
+func (monitor *ADCMonitor) Watch(ain AInName, alarm ADCAlarm) error {
+	if monitor.stop != nil {
+		return fmt.Errorf("ADCMonitor already started")
+	}
+	if _, err := ainChannel(ain); err != nil {
+		return err
+	}
+	if alarm.Low > alarm.High {
+		return fmt.Errorf("ADCAlarm low %f is greater than high %f", alarm.Low, alarm.High)
+	}
+	if err := alarm.Calibration.validate(); err != nil {
+		return err
+	}
+	monitor.channels = append(monitor.channels, &adcMonitorChannel{ain: ain, alarm: alarm, state: ADC_EVENT_ERROR})
+	return nil
+}
+
+// Events returns a channel that receives all events of the monitor
+// in addition to the OnEvent callbacks.
+// If Events is called, the channel must be read
+// or the monitor will block until Stop is called.
+// It must be called before Start or StartBuffered.
+func (monitor *ADCMonitor) Events() chan ADCEvent {
+	if monitor.events == nil {
+		monitor.events = make(chan ADCEvent, 16)
+	}
+	return monitor.events
+}
+
+// Start polls the watched ADC inputs every interval.
+func (monitor *ADCMonitor) Start(interval time.Duration) error {
+	if monitor.stop != nil {
+		return fmt.Errorf("ADCMonitor already started")
+	}
+	if interval <= 0 {
+		return fmt.Errorf("Invalid ADCMonitor interval %s", interval)
+	}
+	monitor.resetChannels()
+	for _, channel := range monitor.channels {
+		adc, err := NewADC(channel.ain)
+		if err != nil {
+			monitor.closeADCs()
+			return err
+		}
+		channel.adc = adc
+	}
+	monitor.stop = make(chan struct{})
+
+	monitor.done.Add(1)
+	go func() {
+		defer monitor.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			for _, channel := range monitor.channels {
+				volts, err := channel.adc.ReadVolts()
+				monitor.update(channel, volts, now, err)
+			}
+			select {
+			case <-monitor.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// StartBuffered monitors the watched ADC inputs with an ADCBuffer
+// of length scans at sampleRate, see NewADCBuffer.
+func (monitor *ADCMonitor) StartBuffered(length, sampleRate int) error {
+	if monitor.stop != nil {
+		return fmt.Errorf("ADCMonitor already started")
+	}
+	ains := make([]AInName, len(monitor.channels))
+	for i, channel := range monitor.channels {
+		ains[i] = channel.ain
+	}
+	buf, err := NewADCBuffer(length, sampleRate, ains...)
+	if err != nil {
+		return err
+	}
+	monitor.resetChannels()
+	monitor.buffer = buf
+	monitor.stop = make(chan struct{})
+
+	monitor.done.Add(1)
+	go func() {
+		defer monitor.done.Done()
+		for sample := range buf.Samples() {
+			for i, channel := range monitor.channels {
+				volts := sample.Values[i] / adcIIOMaxRaw * ADC_REFERENCE_VOLTAGE
+				monitor.update(channel, volts, sample.Time, nil)
+			}
+		}
+		select {
+		case <-monitor.stop:
+		default:
+			// Buffer failed while not stopped
+			for _, channel := range monitor.channels {
+				monitor.update(channel, 0, time.Now(), buf.Err())
+			}
+		}
+	}()
+	return nil
+}
+
+// resetChannels forgets the states and filter histories
+// of a previous run, so that a restart doesn't fire stale events.
+func (monitor *ADCMonitor) resetChannels() {
+	for _, channel := range monitor.channels {
+		channel.state = ADC_EVENT_ERROR
+		channel.failed = false
+		if channel.alarm.Filter != nil {
+			channel.alarm.Filter.Reset()
+		}
+	}
+}
+
+func (monitor *ADCMonitor) update(channel *adcMonitorChannel, volts float32, t time.Time, err error) {
+	if err != nil {
+		// Keep the alarm state, so that the hysteresis
+		// still applies to the first value after the error
+		channel.failed = true
+		monitor.fire(channel, ADCEvent{AIn: channel.ain, Type: ADC_EVENT_ERROR, Time: t, Err: err})
+		return
+	}
+
+	alarm := &channel.alarm
+	value := volts
+	if alarm.Filter != nil {
+		value = alarm.Filter.Filter(value)
+	}
+	value = alarm.Calibration.Apply(value)
+
+	state := channel.state
+	switch state {
+	case ADC_EVENT_ABOVE:
+		if value < alarm.High-alarm.Hysteresis {
+			state = ADC_EVENT_INSIDE
+		}
+	case ADC_EVENT_BELOW:
+		if value > alarm.Low+alarm.Hysteresis {
+			state = ADC_EVENT_INSIDE
+		}
+	}
+	if value > alarm.High {
+		state = ADC_EVENT_ABOVE
+	} else if value < alarm.Low {
+		state = ADC_EVENT_BELOW
+	} else if state == ADC_EVENT_ERROR {
+		state = ADC_EVENT_INSIDE
+	}
+
+	previous, recovered := channel.state, channel.failed
+	channel.state, channel.failed = state, false
+	// The first value inside the window is not reported,
+	// but a recovery from errors always reports the current state once
+	firstInside := previous == ADC_EVENT_ERROR && state == ADC_EVENT_INSIDE
+	if recovered || (state != previous && !firstInside) {
+		monitor.fire(channel, ADCEvent{AIn: channel.ain, Type: state, Value: value, Time: t})
+	}
+}
+
+func (monitor *ADCMonitor) fire(channel *adcMonitorChannel, event ADCEvent) {
+	if channel.alarm.OnEvent != nil {
+		channel.alarm.OnEvent(event)
+	}
+	if monitor.events != nil {
+		select {
+		case monitor.events <- event:
+		case <-monitor.stop:
+		}
+	}
+}
+
+func (monitor *ADCMonitor) closeADCs() {
+	for _, channel := range monitor.channels {
+		if channel.adc != nil {
+			channel.adc.Close()
+			channel.adc = nil
+		}
+	}
+}
+
+// Stop stops the monitoring and closes the used ADC inputs.
+// The monitor can be started again after Stop.
+func (monitor *ADCMonitor) Stop() error {
+	if monitor.stop == nil {
+		return nil
+	}
+	close(monitor.stop)
+	var err error
+	if monitor.buffer != nil {
+		err = monitor.buffer.Close()
+	}
+	monitor.done.Wait()
+	monitor.stop = nil
+	monitor.buffer = nil
+	monitor.closeADCs()
+	return err
+}
@@ -0,0 +1,146 @@
+package bbio
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestADCMonitorUpdate(t *testing.T) {
+	var fired []ADCEventType
+	monitor := NewADCMonitor()
+	err := monitor.Watch("AIN0", ADCAlarm{
+		Low:        0.5,
+		High:       1.5,
+		Hysteresis: 0.1,
+		OnEvent:    func(event ADCEvent) { fired = append(fired, event.Type) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := monitor.channels[0]
+
+	for _, volts := range []float32{1, 1.6, 1.45, 1.39, 0.4, 0.55, 0.61} {
+		monitor.update(channel, volts, time.Now(), nil)
+	}
+	monitor.update(channel, 0, time.Now(), errors.New("read error"))
+	monitor.update(channel, 1, time.Now(), nil)
+	monitor.update(channel, 1, time.Now(), nil)
+
+	expected := []ADCEventType{ADC_EVENT_ABOVE, ADC_EVENT_INSIDE, ADC_EVENT_BELOW, ADC_EVENT_INSIDE, ADC_EVENT_ERROR, ADC_EVENT_INSIDE}
+	if len(fired) != len(expected) {
+		t.Fatalf("fired %v instead of %v", fired, expected)
+	}
+	for i := range expected {
+		if fired[i] != expected[i] {
+			t.Fatalf("fired %v instead of %v", fired, expected)
+		}
+	}
+}
+
+func TestADCMonitorRecovery(t *testing.T) {
+	var fired []ADCEvent
+	monitor := NewADCMonitor()
+	err := monitor.Watch("AIN0", ADCAlarm{
+		Low:        0.5,
+		High:       1.5,
+		Hysteresis: 0.1,
+		OnEvent:    func(event ADCEvent) { fired = append(fired, event) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := monitor.channels[0]
+	readErr := errors.New("read error")
+
+	tests := []struct {
+		volts    float32
+		err      error
+		expected []ADCEventType
+	}{
+		// Errors before the first value
+		{0, readErr, []ADCEventType{ADC_EVENT_ERROR}},
+		{0, readErr, []ADCEventType{ADC_EVENT_ERROR}},
+		{1, nil, []ADCEventType{ADC_EVENT_INSIDE}},
+		{1.6, nil, []ADCEventType{ADC_EVENT_ABOVE}},
+		// Still above after the error, reported once
+		{0, readErr, []ADCEventType{ADC_EVENT_ERROR}},
+		{1.6, nil, []ADCEventType{ADC_EVENT_ABOVE}},
+		{1.7, nil, nil},
+		// Within the hysteresis after the error stays above
+		{0, readErr, []ADCEventType{ADC_EVENT_ERROR}},
+		{1.45, nil, []ADCEventType{ADC_EVENT_ABOVE}},
+		{1.45, nil, nil},
+		// Changed state after the error, reported once
+		{0, readErr, []ADCEventType{ADC_EVENT_ERROR}},
+		{0.4, nil, []ADCEventType{ADC_EVENT_BELOW}},
+		{0.3, nil, nil},
+	}
+	for i, test := range tests {
+		fired = nil
+		monitor.update(channel, test.volts, time.Now(), test.err)
+		if len(fired) != len(test.expected) {
+			t.Fatalf("update %d fired %v instead of %v", i, fired, test.expected)
+		}
+		for j := range fired {
+			if fired[j].Type != test.expected[j] {
+				t.Fatalf("update %d fired %v instead of %v", i, fired, test.expected)
+			}
+		}
+	}
+}
+
+func TestADCMonitorWatch(t *testing.T) {
+	monitor := NewADCMonitor()
+	if monitor.Watch("AIN8", NewADCAlarmAbove(1, 0, nil)) == nil {
+		t.Error("expected error for invalid ADC input")
+	}
+	if monitor.Watch("AIN0", ADCAlarm{Low: 2, High: 1}) == nil {
+		t.Error("expected error for low above high")
+	}
+	alarm := NewADCAlarmAbove(1, 0, nil)
+	alarm.Calibration = &ADCCalibration{Table: []ADCCalibrationPoint{{1, 1}}}
+	if monitor.Watch("AIN0", alarm) == nil {
+		t.Error("expected error for single point calibration table")
+	}
+	if monitor.Start(0) == nil {
+		t.Error("expected error for zero interval")
+	}
+}
+
+func TestADCMonitorStopWithUnreadEvents(t *testing.T) {
+	monitor := NewADCMonitor()
+	err := monitor.Watch("AIN0", NewADCAlarmAbove(1, 0, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor.Events()
+	channel := monitor.channels[0]
+
+	// Simulate a running monitor whose events are never read
+	monitor.stop = make(chan struct{})
+	monitor.done.Add(1)
+	go func() {
+		defer monitor.done.Done()
+		for i := 0; i < 100; i++ {
+			monitor.update(channel, float32(i%2)*2, time.Now(), nil)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan error)
+	go func() { stopped <- monitor.Stop() }()
+	select {
+	case err = <-stopped:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked on unread events")
+	}
+
+	monitor.resetChannels()
+	if channel.state != ADC_EVENT_ERROR {
+		t.Error("channel state not reset")
+	}
+}
@@ -0,0 +1,109 @@
+package bbio
+
+import (
+	"fmt"
+	"time"
+)
+
+// ADCScan reads a set of ADC inputs together with a shared timestamp,
+// for example voltage and current for a power calculation.
+// If the TI-am335x-adc IIO buffer is available, all inputs are sampled
+// by the hardware in one scan sequence at the sample rate given to NewADCScan
+// and Read returns the scans in the order they were taken.
+// Else the inputs are read one after another as fast as possible
+// and the timestamp is the middle between the first and the last read.
+type ADCScan struct {
+	ains   []AInName
+	buffer *ADCBuffer
+	adcs   []*ADC
+}
+
+// NewADCScan returns an ADCScan for ains. length and sampleRate
+// configure the IIO buffer if available, see NewADCBuffer.
+// If the IIO buffer can't be set up, the inputs are read sequentially.
+func NewADCScan(length, sampleRate int, ains ...AInName) (*ADCScan, error) {
+	if len(ains) == 0 {
+		return nil, fmt.Errorf("No ADC inputs for ADCScan")
+	}
+	scan := &ADCScan{ains: ains}
+
+	// Any IIO buffer setup error falls back to sequential reads
+	buffer, err := NewADCBuffer(length, sampleRate, ains...)
+	if err == nil {
+		scan.buffer = buffer
+		return scan, nil
+	}
+
+	scan.adcs = make([]*ADC, len(ains))
+	for i, ain := range ains {
+		adc, err := NewADC(ain)
+		if err != nil {
+			scan.Close()
+			return nil, err
+		}
+		scan.adcs[i] = adc
+	}
+	return scan, nil
+}
+
+// AIns returns the ADC inputs of the scan
+// in the order of ADCBufferSample.Values.
+func (scan *ADCScan) AIns() []AInName {
+	return scan.ains
+}
+
+// Buffered returns if the scan uses the IIO buffer
+// for simultaneous sampling.
+func (scan *ADCScan) Buffered() bool {
+	return scan.buffer != nil
+}
+
+// Read returns the raw values of all ADC inputs of the scan.
+func (scan *ADCScan) Read() (sample ADCBufferSample, err error) {
+	if scan.buffer != nil {
+		return scan.buffer.ReadSample()
+	}
+	sample.Values = make([]float32, len(scan.adcs))
+	start := time.Now()
+	for i, adc := range scan.adcs {
+		sample.Values[i], err = adc.Read()
+		if err != nil {
+			return sample, err
+		}
+	}
+	sample.Time = start.Add(time.Since(start) / 2)
+	return sample, nil
+}
+
+// ReadVolts returns the voltages of all ADC inputs of the scan
+// in the order of AIns.
+func (scan *ADCScan) ReadVolts() (t time.Time, volts []float32, err error) {
+	sample, err := scan.Read()
+	if err != nil {
+		return t, nil, err
+	}
+	maxRaw := adcMaxRaw
+	if scan.buffer != nil {
+		maxRaw = adcIIOMaxRaw
+	}
+	for i := range sample.Values {
+		sample.Values[i] = sample.Values[i] / maxRaw * ADC_REFERENCE_VOLTAGE
+	}
+	return sample.Time, sample.Values, nil
+}
+
+func (scan *ADCScan) Close() error {
+	if scan.buffer != nil {
+		return scan.buffer.Close()
+	}
+	var firstErr error
+	for _, adc := range scan.adcs {
+		if adc == nil {
+			continue
+		}
+		if err := adc.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,46 @@
+package bbio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestADCScanSequential(t *testing.T) {
+	dir := useTestADCIIODir(t)
+	for ain, raw := range map[string]string{"in_voltage0_raw": "4095\n", "in_voltage5_raw": "0\n"} {
+		err := ioutil.WriteFile(filepath.Join(dir, ain), []byte(raw), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewADCScan(16, 1000); err == nil {
+		t.Error("expected error for scan without inputs")
+	}
+	if _, err := NewADCScan(16, 1000, AIN0, AIN1); err == nil {
+		t.Error("expected error for missing input")
+	}
+
+	// Without IIO buffer the inputs are read sequentially
+	scan, err := NewADCScan(16, 1000, AIN5, AIN0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer scan.Close()
+	if scan.Buffered() {
+		t.Skip("IIO buffer available")
+	}
+	before := time.Now()
+	timestamp, volts, err := scan.ReadVolts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volts) != 2 || volts[0] != 0 || volts[1] != ADC_REFERENCE_VOLTAGE {
+		t.Errorf("volts %v of %v", volts, scan.AIns())
+	}
+	if timestamp.Before(before) || timestamp.After(time.Now()) {
+		t.Errorf("timestamp %s not during the read", timestamp)
+	}
+}
@@ -0,0 +1,63 @@
+package bbio
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// useTestADCIIODir makes NewADC use the in_voltageN_raw files
+// in a temporary directory and returns the directory.
+func useTestADCIIODir(t *testing.T) string {
+	t.Helper()
+	initialized, iioDir, maxRaw := adcInitialized, adcIIODir, adcMaxRaw
+	t.Cleanup(func() {
+		adcInitialized, adcIIODir, adcMaxRaw = initialized, iioDir, maxRaw
+	})
+	adcInitialized, adcIIODir, adcMaxRaw = true, t.TempDir(), 4095
+	return adcIIODir
+}
+
+func TestADCIIO(t *testing.T) {
+	dir := useTestADCIIODir(t)
+	filename := filepath.Join(dir, "in_voltage3_raw")
+	err := ioutil.WriteFile(filename, []byte("4095\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adc, err := NewADC(AIN3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adc.Close()
+	if volts, err := adc.ReadVolts(); volts != ADC_REFERENCE_VOLTAGE || err != nil {
+		t.Errorf("ReadVolts returned %f, %v", volts, err)
+	}
+
+	// Every read starts at the beginning of the file
+	err = ioutil.WriteFile(filename, []byte("1365\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := adc.Read(); raw != 1365 || err != nil {
+		t.Errorf("Read returned %f, %v", raw, err)
+	}
+	if value := adc.ReadValue(); value != 1365.0/4095 {
+		t.Errorf("ReadValue returned %f", value)
+	}
+
+	ioutil.WriteFile(filename, []byte("busy\n"), 0644)
+	if _, err = adc.Read(); err == nil {
+		t.Error("expected error for invalid value")
+	} else if _, ok := err.(ErrADC); !ok {
+		t.Errorf("Read returned %T instead of ErrADC", err)
+	}
+
+	if _, err = NewADC(AIN2); err == nil {
+		t.Error("expected error for missing input file")
+	}
+	if _, err = NewADC("AIN9"); err == nil {
+		t.Error("expected error for invalid input name")
+	}
+}
@@ -0,0 +1,314 @@
+package bbio
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LineReader reads lines that end with one of several terminators.
+type LineReader struct {
+	r           *bufio.Reader
+	terminators []string
+	partial     []byte // line read before a read timeout
+}
+
+// NewLineReader returns a LineReader for r.
+// Without terminators lines end with "\n" and a trailing "\r" is removed.
+func NewLineReader(r io.Reader, terminators ...string) *LineReader {
+	return &LineReader{r: bufio.NewReader(r), terminators: terminators}
+}
+
+// ReadLine returns the next line without its terminator.
+// At the end of the input a non terminated line is returned with io.EOF.
+// After a read timeout ReadLine can be called again,
+// a partially read line is kept and continued.
+func (lr *LineReader) ReadLine() (string, error) {
+	if len(lr.terminators) == 0 {
+		data, err := lr.r.ReadBytes('\n')
+		lr.partial = append(lr.partial, data...)
+		if err != nil {
+			return lr.readError(err)
+		}
+		line := strings.TrimSuffix(lr.takePartial(), "\n")
+		return strings.TrimSuffix(line, "\r"), nil
+	}
+
+	for {
+		b, err := lr.r.ReadByte()
+		if err != nil {
+			return lr.readError(err)
+		}
+		lr.partial = append(lr.partial, b)
+		for _, terminator := range lr.terminators {
+			if bytes.HasSuffix(lr.partial, []byte(terminator)) {
+				line := lr.takePartial()
+				return line[:len(line)-len(terminator)], nil
+			}
+		}
+	}
+}
+
+func (lr *LineReader) takePartial() string {
+	line := string(lr.partial)
+	lr.partial = lr.partial[:0]
+	return line
+}
+
+// readError keeps the partial line for timeouts
+// and returns it with all other errors.
+func (lr *LineReader) readError(err error) (string, error) {
+	if IsTimeout(err) {
+		return "", err
+	}
+	return lr.takePartial(), err
+}
+
+var ErrATTimeout = errors.New("AT command timeout")
+
+// ErrAT is returned for an AT command that
+// was answered with an error response.
+type ErrAT struct {
+	Command  string
+	Response string // like "ERROR" or "+CME ERROR: 10"
+}
+
+func (errAT ErrAT) Error() string {
+	return fmt.Sprintf("AT command '%s' error: %s", errAT.Command, errAT.Response)
+}
+
+var atErrorResponses = []string{
+	"ERROR",
+	"+CME ERROR",
+	"+CMS ERROR",
+	"NO CARRIER",
+	"NO DIALTONE",
+	"NO ANSWER",
+	"BUSY",
+}
+
+type atURCHandler struct {
+	prefix  string
+	handler func(line string)
+}
+
+type atCommand struct {
+	command string
+	// name is the command without AT and parameters like "+CREG",
+	// it identifies response lines that look like unsolicited result codes
+	name   string
+	finals []string
+	lines  []string
+	result chan error
+}
+
+// ATModem sends AT commands to a modem connected
+// to an UART or any other io.ReadWriter.
+// A goroutine reads the lines from the modem, lines that
+// don't belong to the response of a command are passed to
+// the handlers registered with OnURC.
+// The goroutine ends when reading fails,
+// for example after the UART was closed.
+type ATModem struct {
+	w io.Writer
+
+	commandMutex sync.Mutex
+	mutex        sync.Mutex
+	pending      *atCommand
+	handlers     []atURCHandler
+	err          error
+}
+
+// NewATModem returns an ATModem for rw.
+// Without terminators response lines end with "\n".
+func NewATModem(rw io.ReadWriter, terminators ...string) *ATModem {
+	modem := &ATModem{w: rw}
+	go modem.readLoop(NewLineReader(rw, terminators...))
+	return modem
+}
+
+// OnURC registers handler for unsolicited result codes starting with prefix,
+// like "+CREG:" or "RING". An empty prefix matches all lines
+// that don't belong to a pending command.
+// Lines starting with the name of the pending command
+// are passed to the command, so "+CREG: 0,1" is
+// the response to "AT+CREG?" and no URC.
+// Handlers are called from the reading goroutine
+// and must not send commands themselves.
+func (modem *ATModem) OnURC(prefix string, handler func(line string)) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	modem.handlers = append(modem.handlers, atURCHandler{prefix, handler})
+}
+
+// Err returns the error that ended reading from the modem.
+func (modem *ATModem) Err() error {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	return modem.err
+}
+
+// Command sends command and waits up to timeout for the final response.
+// It returns the lines of the response without echo and final response.
+// Final error responses like ERROR or +CME ERROR are returned as ErrAT.
+func (modem *ATModem) Command(command string, timeout time.Duration) ([]string, error) {
+	return modem.CommandFinal(command, timeout)
+}
+
+// CommandFinal is like Command, but lines starting with one of
+// finals are final responses, too.
+// They are returned as the last line of the response.
+// This is needed for commands that finish with a custom response
+// like "CONNECT" or "+JOIN: Done".
+// If finals are given OK is not a final response,
+// because some modules answer OK before the custom final response.
+func (modem *ATModem) CommandFinal(command string, timeout time.Duration, finals ...string) ([]string, error) {
+	modem.commandMutex.Lock()
+	defer modem.commandMutex.Unlock()
+
+	cmd := &atCommand{
+		command: command,
+		name:    atCommandName(command),
+		finals:  finals,
+		result:  make(chan error, 1),
+	}
+	modem.mutex.Lock()
+	if modem.err != nil {
+		modem.mutex.Unlock()
+		return nil, modem.err
+	}
+	modem.pending = cmd
+	modem.mutex.Unlock()
+
+	_, err := modem.w.Write([]byte(command + "\r"))
+	if err != nil {
+		modem.cancel(cmd)
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err = <-cmd.result:
+	case <-timer.C:
+		modem.cancel(cmd)
+		// The final response could have arrived in the meantime
+		select {
+		case err = <-cmd.result:
+		default:
+			return nil, ErrATTimeout
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cmd.lines, nil
+}
+
+func (modem *ATModem) cancel(cmd *atCommand) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+	if modem.pending == cmd {
+		modem.pending = nil
+	}
+}
+
+// atCommandName returns "+CREG" for "AT+CREG?" or "AT+CREG=1".
+func atCommandName(command string) string {
+	name := strings.ToUpper(strings.TrimSpace(command))
+	if !strings.HasPrefix(name, "AT") {
+		return ""
+	}
+	name = name[2:]
+	if i := strings.IndexAny(name, "=?"); i != -1 {
+		name = name[:i]
+	}
+	return name
+}
+
+func (modem *ATModem) readLoop(lines *LineReader) {
+	for {
+		line, err := lines.ReadLine()
+		if IsTimeout(err) {
+			continue
+		}
+		if err != nil {
+			modem.mutex.Lock()
+			modem.err = err
+			if modem.pending != nil {
+				modem.pending.result <- err
+				modem.pending = nil
+			}
+			modem.mutex.Unlock()
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if handler := modem.handleLine(line); handler != nil {
+			handler(line)
+		}
+	}
+}
+
+// handleLine adds line to the response of the pending command
+// or returns the URC handler for it.
+func (modem *ATModem) handleLine(line string) func(line string) {
+	modem.mutex.Lock()
+	defer modem.mutex.Unlock()
+
+	// Echo and final responses always belong to the pending command
+	cmd := modem.pending
+	if cmd != nil {
+		switch {
+		case line == strings.TrimSpace(cmd.command):
+			return nil
+		case line == "OK":
+			if len(cmd.finals) > 0 {
+				return nil
+			}
+			cmd.result <- nil
+			modem.pending = nil
+			return nil
+		case atHasPrefix(line, cmd.finals):
+			cmd.lines = append(cmd.lines, line)
+			cmd.result <- nil
+			modem.pending = nil
+			return nil
+		case atHasPrefix(line, atErrorResponses):
+			cmd.result <- ErrAT{cmd.command, line}
+			modem.pending = nil
+			return nil
+		}
+	}
+
+	response := cmd != nil && cmd.name != "" && strings.HasPrefix(line, cmd.name)
+	for _, urc := range modem.handlers {
+		if !strings.HasPrefix(line, urc.prefix) {
+			continue
+		}
+		if cmd != nil && (urc.prefix == "" || response) {
+			continue
+		}
+		return urc.handler
+	}
+	if cmd != nil {
+		cmd.lines = append(cmd.lines, line)
+	}
+	return nil
+}
+
+func atHasPrefix(line string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package bbio
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineReader(t *testing.T) {
+	lines := NewLineReader(newChunkReader([]byte("first\r\nsec"), []byte("ond\nlast")))
+	var result []string
+	for {
+		line, err := lines.ReadLine()
+		if IsTimeout(err) {
+			continue
+		}
+		result = append(result, line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []string{"first", "second", "last"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("lines %q instead of %q", result, expected)
+	}
+
+	lines = NewLineReader(newChunkReader([]byte("+JOIN: Do"), []byte("ne\r\n> ")), "\r\n", "> ")
+	result = nil
+	for {
+		line, err := lines.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			result = append(result, line)
+		}
+	}
+	if expected := []string{"+JOIN: Done", ""}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("lines %q instead of %q", result, expected)
+	}
+}
+
+// atFakeModem answers commands with the responses for them
+// and returns a read timeout before every read.
+type atFakeModem struct {
+	responses map[string]string
+	r         *io.PipeReader
+	w         *io.PipeWriter
+	timeout   bool
+}
+
+func newATFakeModem(responses map[string]string) *atFakeModem {
+	r, w := io.Pipe()
+	return &atFakeModem{responses: responses, r: r, w: w}
+}
+
+func (fake *atFakeModem) Read(p []byte) (int, error) {
+	fake.timeout = !fake.timeout
+	if fake.timeout {
+		return 0, os.ErrDeadlineExceeded
+	}
+	return fake.r.Read(p)
+}
+
+func (fake *atFakeModem) Write(p []byte) (int, error) {
+	command := strings.TrimSuffix(string(p), "\r")
+	if response, ok := fake.responses[command]; ok {
+		fake.w.Write([]byte(command + "\r\r\n" + response))
+	}
+	return len(p), nil
+}
+
+func TestATModem(t *testing.T) {
+	fake := newATFakeModem(map[string]string{
+		"AT":         "OK\r\n",
+		"AT+CGSN":    "\r\n490154203237518\r\n\r\nOK\r\n",
+		"AT+CREG?":   "\r\n+CREG: 0,1\r\n\r\nOK\r\n",
+		"AT+COPS=99": "\r\n+CME ERROR: 3\r\n",
+		"ATD123":     "\r\nOK\r\n+JOIN: Done\r\n",
+	})
+	modem := NewATModem(fake)
+	urcs := make(chan string, 4)
+	rings := make(chan string, 4)
+	modem.OnURC("RING", func(line string) { rings <- line })
+	modem.OnURC("", func(line string) { urcs <- line })
+
+	lines, err := modem.Command("AT", time.Second)
+	if err != nil || len(lines) != 0 {
+		t.Errorf("AT returned %q, %v", lines, err)
+	}
+	lines, err = modem.Command("AT+CGSN", time.Second)
+	if err != nil || !reflect.DeepEqual(lines, []string{"490154203237518"}) {
+		t.Errorf("AT+CGSN returned %q, %v", lines, err)
+	}
+	lines, err = modem.Command("AT+CREG?", time.Second)
+	if err != nil || !reflect.DeepEqual(lines, []string{"+CREG: 0,1"}) {
+		t.Errorf("AT+CREG? returned %q, %v", lines, err)
+	}
+	_, err = modem.Command("AT+COPS=99", time.Second)
+	var errAT ErrAT
+	if !errors.As(err, &errAT) || errAT.Response != "+CME ERROR: 3" {
+		t.Errorf("AT+COPS=99 returned %v", err)
+	}
+	lines, err = modem.CommandFinal("ATD123", time.Second, "+JOIN:")
+	if err != nil || !reflect.DeepEqual(lines, []string{"+JOIN: Done"}) {
+		t.Errorf("ATD123 returned %q, %v", lines, err)
+	}
+	_, err = modem.Command("AT+UNKNOWN", 20*time.Millisecond)
+	if err != ErrATTimeout {
+		t.Errorf("command without response returned %v", err)
+	}
+	if len(urcs) != 0 {
+		t.Errorf("responses passed as URC: %q", <-urcs)
+	}
+
+	fake.w.Write([]byte("RING\r\n+CREG: 5\r\n"))
+	for _, c := range []struct {
+		urcs     chan string
+		expected string
+	}{{rings, "RING"}, {urcs, "+CREG: 5"}} {
+		select {
+		case line := <-c.urcs:
+			if line != c.expected {
+				t.Errorf("URC %q instead of %q", line, c.expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no URC %q", c.expected)
+		}
+	}
+
+	fake.w.Close()
+	_, err = modem.Command("AT", time.Second)
+	if err != io.EOF {
+		t.Errorf("command after end of input returned %v", err)
+	}
+}
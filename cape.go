@@ -0,0 +1,292 @@
+package bbio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// I2C addresses of the board and cape EEPROMs
+const (
+	BOARD_EEPROM_ADDRESS      = 0x50
+	CAPE_EEPROM_FIRST_ADDRESS = 0x54
+	CAPE_EEPROM_LAST_ADDRESS  = 0x57
+)
+
+// Device names of the I2C controllers in sysfs,
+// used to find their bus numbers which differ between kernels
+const (
+	i2c0Device = "44e0b000.i2c"
+	i2c2Device = "4819c000.i2c"
+)
+
+const (
+	eepromMagic     = 0xEE3355AA // 0xAA 0x55 0x33 0xEE little endian
+	capeEEPROMSize  = 244
+	capeEEPROMPins  = 74
+	boardEEPROMSize = 60
+	i2cDevicesDir   = "/sys/bus/i2c/devices"
+)
+
+// CapeEEPROM is the identification data of a cape
+// in the format of the BeagleBone System Reference Manual.
+type CapeEEPROM struct {
+	Address        int // I2C address of the EEPROM
+	EEPROMRevision string
+	BoardName      string
+	Version        string
+	Manufacturer   string
+	PartNumber     string
+	NumPins        int
+	SerialNumber   string
+	// PinUsage holds the pin configuration for every pin in the order
+	// of the System Reference Manual, bit 15 marks a used pin
+	PinUsage []uint16
+	// Maximum currents in milliampere
+	CurrentVDD3V3B int
+	CurrentVDD5V   int
+	CurrentSYS5V   int
+	// DCSupplied is the current in milliampere
+	// the cape supplies to the board
+	DCSupplied int
+}
+
+// DeviceTree returns the name of the device tree overlay of the cape
+// as used by the cape manager, like "BB-UART1:00A0".
+// The version is omitted if the EEPROM has none.
+func (cape *CapeEEPROM) DeviceTree() string {
+	if cape.Version == "" {
+		return cape.PartNumber
+	}
+	return cape.PartNumber + ":" + cape.Version
+}
+
+// LoadDeviceTree loads the device tree overlay of the cape
+// if the cape manager has no slot with its part number and version.
+func (cape *CapeEEPROM) LoadDeviceTree() error {
+	if cape.PartNumber == "" {
+		return fmt.Errorf("Cape '%s' has no part number", cape.BoardName)
+	}
+	dir, err := BuildPath("/sys/devices", "bone_capemgr")
+	if err != nil {
+		return err
+	}
+	slots, err := ioutil.ReadFile(dir + "/slots")
+	if err != nil {
+		return err
+	}
+	if capeSlotLoaded(string(slots), cape.PartNumber, cape.Version) {
+		return nil
+	}
+	return LoadDeviceTree(cape.DeviceTree())
+}
+
+// capeSlotLoaded returns true if slots of the cape manager have
+// a line with partNumber and version, or any version if version is empty.
+// The lines end with board name, version, manufacturer and part number
+// separated by commas, like:
+//
+//	7: ff:P-O-L Override Board Name,00A0,Override Manuf,BB-UART1
+func capeSlotLoaded(slots, partNumber, version string) bool {
+	for _, line := range strings.Split(slots, "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 || strings.TrimSpace(fields[len(fields)-1]) != partNumber {
+			continue
+		}
+		if version == "" || strings.TrimSpace(fields[len(fields)-3]) == version {
+			return true
+		}
+	}
+	return false
+}
+
+// BoardEEPROM is the identification data of the BeagleBone itself.
+type BoardEEPROM struct {
+	BoardName    string // like "A335BONE" or "A335BNLT" for the BeagleBone Black
+	Version      string
+	SerialNumber string
+	Config       string
+}
+
+func eepromString(data []byte) string {
+	return strings.TrimSpace(string(bytes.TrimRight(data, "\x00\xff")))
+}
+
+func checkEEPROMMagic(data []byte) error {
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != eepromMagic {
+		return errors.New("Invalid EEPROM header")
+	}
+	return nil
+}
+
+// ParseCapeEEPROM parses the first 244 bytes of a cape EEPROM.
+func ParseCapeEEPROM(data []byte) (*CapeEEPROM, error) {
+	if len(data) < capeEEPROMSize {
+		return nil, fmt.Errorf("Cape EEPROM data has %d bytes instead of %d", len(data), capeEEPROMSize)
+	}
+	err := checkEEPROMMagic(data)
+	if err != nil {
+		return nil, err
+	}
+	cape := &CapeEEPROM{
+		EEPROMRevision: eepromString(data[4:6]),
+		BoardName:      eepromString(data[6:38]),
+		Version:        eepromString(data[38:42]),
+		Manufacturer:   eepromString(data[42:58]),
+		PartNumber:     eepromString(data[58:74]),
+		NumPins:        int(binary.BigEndian.Uint16(data[74:76])),
+		SerialNumber:   eepromString(data[76:88]),
+		PinUsage:       make([]uint16, capeEEPROMPins),
+		CurrentVDD3V3B: int(binary.BigEndian.Uint16(data[236:238])),
+		CurrentVDD5V:   int(binary.BigEndian.Uint16(data[238:240])),
+		CurrentSYS5V:   int(binary.BigEndian.Uint16(data[240:242])),
+		DCSupplied:     int(binary.BigEndian.Uint16(data[242:244])),
+	}
+	for i := range cape.PinUsage {
+		cape.PinUsage[i] = binary.BigEndian.Uint16(data[88+2*i:])
+	}
+	return cape, nil
+}
+
+// ParseBoardEEPROM parses the first 60 bytes of the board EEPROM.
+func ParseBoardEEPROM(data []byte) (*BoardEEPROM, error) {
+	if len(data) < boardEEPROMSize {
+		return nil, fmt.Errorf("Board EEPROM data has %d bytes instead of %d", len(data), boardEEPROMSize)
+	}
+	err := checkEEPROMMagic(data)
+	if err != nil {
+		return nil, err
+	}
+	return &BoardEEPROM{
+		BoardName:    eepromString(data[4:12]),
+		Version:      eepromString(data[12:16]),
+		SerialNumber: eepromString(data[16:28]),
+		Config:       eepromString(data[28:60]),
+	}, nil
+}
+
+// i2cBusNr returns the bus number of the I2C controller device,
+// or fallback if it can't be found in sysfs.
+func i2cBusNr(device string, fallback int) int {
+	buses, err := ioutil.ReadDir(i2cDevicesDir)
+	if err != nil {
+		return fallback
+	}
+	for _, bus := range buses {
+		if !strings.HasPrefix(bus.Name(), "i2c-") {
+			continue
+		}
+		link, err := filepath.EvalSymlinks(path.Join(i2cDevicesDir, bus.Name()))
+		if err != nil || path.Base(path.Dir(link)) != device {
+			continue
+		}
+		nr, err := strconv.Atoi(strings.TrimPrefix(bus.Name(), "i2c-"))
+		if err == nil {
+			return nr
+		}
+	}
+	return fallback
+}
+
+// CapeEEPROMBus returns the number of the I2C bus with the cape EEPROMs,
+// which is the I2C2 controller. Older kernels name it /dev/i2c-1.
+func CapeEEPROMBus() int {
+	return i2cBusNr(i2c2Device, 2)
+}
+
+// readEEPROM is readI2CEEPROM, it is replaced by tests.
+var readEEPROM = readI2CEEPROM
+
+// readI2CEEPROM reads the beginning of an EEPROM.
+// If a kernel driver claimed the EEPROM its sysfs file is used,
+// else the EEPROM is read directly.
+func readI2CEEPROM(bus, address int, eepromType EEPROM24CxxType, length int) ([]byte, error) {
+	data := make([]byte, length)
+	file, err := os.Open(fmt.Sprintf("%s/%d-%04x/eeprom", i2cDevicesDir, bus, address))
+	if err == nil {
+		defer file.Close()
+		_, err = file.ReadAt(data, 0)
+		return data, err
+	}
+
+	i2cBus, err := NewI2CBus(bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2cBus.Close()
+	eeprom, err := NewEEPROM24Cxx(i2cBus, address, eepromType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = eeprom.ReadAt(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadCapeEEPROM reads the identification of the cape
+// with the EEPROM at address on the cape EEPROM bus.
+func ReadCapeEEPROM(address int) (*CapeEEPROM, error) {
+	if address < CAPE_EEPROM_FIRST_ADDRESS || address > CAPE_EEPROM_LAST_ADDRESS {
+		return nil, fmt.Errorf("Invalid cape EEPROM address 0x%02X", address)
+	}
+	data, err := readEEPROM(CapeEEPROMBus(), address, EEPROM_24C256, capeEEPROMSize)
+	if err != nil {
+		return nil, err
+	}
+	cape, err := ParseCapeEEPROM(data)
+	if err != nil {
+		return nil, fmt.Errorf("Cape EEPROM 0x%02X: %s", address, err)
+	}
+	cape.Address = address
+	return cape, nil
+}
+
+// ListCapes returns the identifications of all attached capes.
+// Addresses without a responding EEPROM are skipped.
+func ListCapes() ([]*CapeEEPROM, error) {
+	var capes []*CapeEEPROM
+	for address := CAPE_EEPROM_FIRST_ADDRESS; address <= CAPE_EEPROM_LAST_ADDRESS; address++ {
+		cape, err := ReadCapeEEPROM(address)
+		if isAbsentI2CDevice(err) {
+			continue
+		}
+		if err != nil {
+			return capes, err
+		}
+		capes = append(capes, cape)
+	}
+	return capes, nil
+}
+
+// isAbsentI2CDevice returns true for the errors of reading a not
+// connected device directly or via the sysfs file of a kernel driver.
+// Reading directly, other errors like timeouts are bus faults and not ignored.
+// The at24 driver retries a not acknowledging EEPROM until its timeout
+// and returns ETIMEDOUT, or EIO from some bus drivers.
+func isAbsentI2CDevice(err error) bool {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) && strings.HasPrefix(pathErr.Path, i2cDevicesDir+"/") {
+		return errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.ETIMEDOUT) || errors.Is(err, syscall.EIO)
+	}
+	return errors.Is(err, ErrI2CNAK) || errors.Is(err, syscall.ENXIO)
+}
+
+// ReadBoardEEPROM reads the identification of the BeagleBone
+// from the EEPROM on the I2C0 bus.
+func ReadBoardEEPROM() (*BoardEEPROM, error) {
+	data, err := readEEPROM(i2cBusNr(i2c0Device, 0), BOARD_EEPROM_ADDRESS, EEPROM_24C256, boardEEPROMSize)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBoardEEPROM(data)
+}
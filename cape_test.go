@@ -0,0 +1,204 @@
+package bbio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func testEEPROM(size int, fields map[int]string) []byte {
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, eepromMagic)
+	for offset, value := range fields {
+		copy(data[offset:], value)
+	}
+	return data
+}
+
+func TestParseCapeEEPROM(t *testing.T) {
+	data := testEEPROM(capeEEPROMSize, map[int]string{
+		4:  "A1",
+		6:  "BeagleBone Serial Cape",
+		38: "00A0",
+		42: "Example Inc",
+		58: "BB-UART1",
+		76: "1234BBBK5678",
+	})
+	binary.BigEndian.PutUint16(data[74:], 2)
+	binary.BigEndian.PutUint16(data[88+2*3:], 0x8023)
+	binary.BigEndian.PutUint16(data[236:], 250)
+	binary.BigEndian.PutUint16(data[242:], 1000)
+
+	cape, err := ParseCapeEEPROM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cape.EEPROMRevision != "A1" || cape.BoardName != "BeagleBone Serial Cape" || cape.Version != "00A0" ||
+		cape.Manufacturer != "Example Inc" || cape.PartNumber != "BB-UART1" || cape.SerialNumber != "1234BBBK5678" {
+		t.Errorf("parsed strings %+v", cape)
+	}
+	if cape.NumPins != 2 || len(cape.PinUsage) != capeEEPROMPins || cape.PinUsage[3] != 0x8023 || cape.PinUsage[4] != 0 {
+		t.Errorf("pins %d, usage %X", cape.NumPins, cape.PinUsage)
+	}
+	if cape.CurrentVDD3V3B != 250 || cape.DCSupplied != 1000 {
+		t.Errorf("currents %d, %d", cape.CurrentVDD3V3B, cape.DCSupplied)
+	}
+	if cape.DeviceTree() != "BB-UART1:00A0" {
+		t.Errorf("device tree %s", cape.DeviceTree())
+	}
+	cape.Version = ""
+	if cape.DeviceTree() != "BB-UART1" {
+		t.Errorf("device tree without version %s", cape.DeviceTree())
+	}
+
+	if _, err = ParseCapeEEPROM(data[:capeEEPROMSize-1]); err == nil {
+		t.Error("expected error for short data")
+	}
+	data[0] = 0xFF
+	if _, err = ParseCapeEEPROM(data); err == nil {
+		t.Error("expected error for invalid header")
+	}
+}
+
+func TestParseBoardEEPROM(t *testing.T) {
+	data := testEEPROM(boardEEPROMSize, map[int]string{
+		4:  "A335BNLT",
+		12: "00C0",
+		16: "1813BBBK1234",
+	})
+	// Unprogrammed bytes read as 0xFF
+	for i := 28; i < boardEEPROMSize; i++ {
+		data[i] = 0xFF
+	}
+	board, err := ParseBoardEEPROM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := BoardEEPROM{BoardName: "A335BNLT", Version: "00C0", SerialNumber: "1813BBBK1234"}
+	if *board != expected {
+		t.Errorf("parsed %+v", board)
+	}
+	if _, err = ParseBoardEEPROM(make([]byte, boardEEPROMSize)); err == nil {
+		t.Error("expected error for invalid header")
+	}
+}
+
+func sysfsEEPROMError(address int, errno syscall.Errno) error {
+	filename := fmt.Sprintf("%s/2-%04x/eeprom", i2cDevicesDir, address)
+	return &os.PathError{Op: "read", Path: filename, Err: errno}
+}
+
+func TestIsAbsentI2CDevice(t *testing.T) {
+	absent := []error{
+		ErrI2C{"Tx", syscall.EREMOTEIO},
+		ErrI2C{"Tx", syscall.ENXIO},
+		sysfsEEPROMError(0x54, syscall.ENXIO),
+		sysfsEEPROMError(0x54, syscall.ETIMEDOUT),
+		sysfsEEPROMError(0x54, syscall.EIO),
+	}
+	for _, err := range absent {
+		if !isAbsentI2CDevice(err) {
+			t.Errorf("%v is no absent device", err)
+		}
+	}
+	faults := []error{
+		nil,
+		ErrI2C{"Tx", syscall.ETIMEDOUT},
+		ErrI2C{"Tx", syscall.EIO},
+		fmt.Errorf("Cape EEPROM 0x54: %w", syscall.EIO),
+		sysfsEEPROMError(0x54, syscall.EACCES),
+		&os.PathError{Op: "open", Path: "/dev/i2c-2", Err: syscall.EIO},
+	}
+	for _, err := range faults {
+		if isAbsentI2CDevice(err) {
+			t.Errorf("%v is an absent device", err)
+		}
+	}
+}
+
+// useTestEEPROMs makes readEEPROM return the result of read.
+func useTestEEPROMs(t *testing.T, read func(address int) ([]byte, error)) {
+	t.Helper()
+	readFunc := readEEPROM
+	t.Cleanup(func() { readEEPROM = readFunc })
+	readEEPROM = func(bus, address int, eepromType EEPROM24CxxType, length int) ([]byte, error) {
+		return read(address)
+	}
+}
+
+func TestListCapes(t *testing.T) {
+	cape := testEEPROM(capeEEPROMSize, map[int]string{6: "Test Cape", 58: "BB-TEST"})
+
+	// Every errno of a missing EEPROM at 0x54 to 0x56 with a cape at 0x57
+	absent := []error{
+		sysfsEEPROMError(0x54, syscall.ETIMEDOUT),
+		sysfsEEPROMError(0x54, syscall.EIO),
+		sysfsEEPROMError(0x54, syscall.ENXIO),
+		ErrI2C{"Transfer", syscall.ENXIO},
+		ErrI2C{"Transfer", syscall.EREMOTEIO},
+	}
+	for _, absentErr := range absent {
+		useTestEEPROMs(t, func(address int) ([]byte, error) {
+			if address == 0x57 {
+				return cape, nil
+			}
+			return nil, absentErr
+		})
+		capes, err := ListCapes()
+		if err != nil || len(capes) != 1 || capes[0].Address != 0x57 || capes[0].PartNumber != "BB-TEST" {
+			t.Errorf("ListCapes with %v returned %+v, %v", absentErr, capes, err)
+		}
+	}
+
+	faults := []error{
+		ErrI2C{"Transfer", syscall.ETIMEDOUT},
+		ErrI2C{"Transfer", syscall.EIO},
+		sysfsEEPROMError(0x55, syscall.EACCES),
+	}
+	for _, faultErr := range faults {
+		useTestEEPROMs(t, func(address int) ([]byte, error) {
+			if address == 0x55 {
+				return nil, faultErr
+			}
+			return cape, nil
+		})
+		capes, err := ListCapes()
+		if err != faultErr || len(capes) != 1 {
+			t.Errorf("ListCapes with %v returned %d capes, %v", faultErr, len(capes), err)
+		}
+	}
+}
+
+func TestCapeSlotLoaded(t *testing.T) {
+	slots := ` 0: 54:PF--- 
+ 1: 55:PF--- 
+ 2: 56:PF--- 
+ 3: 57:PF--- 
+ 4: ff:P-O-L Bone-LT-eMMC-2G,00A0,Texas Instrument,BB-BONE-EMMC-2G
+ 5: ff:P-O-L Bone-Black-HDMI,00A0,Texas Instrument,BB-BONELT-HDMI
+ 7: ff:P-O-L Override Board Name,00A0,Override Manuf,BB-UART10
+`
+	tests := []struct {
+		partNumber, version string
+		loaded              bool
+	}{
+		{"BB-BONELT-HDMI", "00A0", true},
+		{"BB-BONELT-HDMI", "", true},
+		{"BB-BONELT-HDMI", "00A1", false},
+		{"BB-UART10", "00A0", true},
+		// Prefix of a loaded part number
+		{"BB-UART1", "00A0", false},
+		{"BB-UART1", "", false},
+		{"BB-BONELT", "", false},
+		// Board name or manufacturer instead of the part number
+		{"Bone-Black-HDMI", "", false},
+		{"Texas Instrument", "", false},
+	}
+	for _, test := range tests {
+		if loaded := capeSlotLoaded(slots, test.partNumber, test.version); loaded != test.loaded {
+			t.Errorf("%s:%s loaded is %t", test.partNumber, test.version, loaded)
+		}
+	}
+}
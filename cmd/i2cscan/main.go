@@ -0,0 +1,79 @@
+// Command i2cscan lists the devices of an I2C bus like i2cdetect.
+//
+// Usage:
+//
+//	i2cscan [-bus 1] [-identify]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ungerik/go-bbio"
+)
+
+func main() {
+	bus := flag.Int("bus", 1, "I2C bus number")
+	identify := flag.Bool("identify", false, "read chip ID registers of found devices")
+	flag.Parse()
+
+	results, err := bbio.ScanI2C(*bus)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Address grid like i2cdetect
+	found := make(map[int]bbio.I2CScanResult)
+	for _, result := range results {
+		found[result.Address] = result
+	}
+	fmt.Println("     0  1  2  3  4  5  6  7  8  9  a  b  c  d  e  f")
+	for row := 0; row < 0x80; row += 16 {
+		fmt.Printf("%02x: ", row)
+		for address := row; address < row+16; address++ {
+			result, ok := found[address]
+			switch {
+			case address < bbio.I2C_SCAN_FIRST_ADDRESS || address > bbio.I2C_SCAN_LAST_ADDRESS:
+				fmt.Print("   ")
+			case !ok:
+				fmt.Print("-- ")
+			case result.Busy:
+				fmt.Print("UU ")
+			default:
+				fmt.Printf("%02x ", address)
+			}
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+
+	for _, result := range results {
+		fmt.Printf("0x%02x", result.Address)
+		if result.Busy {
+			fmt.Printf(" busy, driver %s", result.Driver)
+		}
+		chips := result.Chips
+		if *identify && !result.Busy {
+			identified, err := bbio.IdentifyI2C(*bus, result.Address)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if len(identified) > 0 {
+				chips = identified
+				fmt.Print(" identified")
+			}
+		}
+		var names []string
+		for _, chip := range chips {
+			names = append(names, fmt.Sprintf("%s (%s)", chip.Name, chip.Description))
+		}
+		if len(names) > 0 {
+			fmt.Printf(": %s", strings.Join(names, ", "))
+		}
+		fmt.Println()
+	}
+}
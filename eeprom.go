@@ -0,0 +1,200 @@
+package bbio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// I2CTransferer is implemented by I2C and I2CBus.
+type I2CTransferer interface {
+	Transfer(messages []I2CMessage) error
+}
+
+// EEPROM24CxxType describes the memory organization of a 24Cxx EEPROM.
+type EEPROM24CxxType struct {
+	Name     string
+	Size     int
+	PageSize int
+	// AddressBytes is the number of memory address bytes (1 or 2).
+	// Memory address bits above them are sent as block-select bits
+	// in the lower bits of the device address.
+	AddressBytes int
+}
+
+var (
+	EEPROM_24C01   = EEPROM24CxxType{"24C01", 128, 8, 1}
+	EEPROM_24C02   = EEPROM24CxxType{"24C02", 256, 8, 1}
+	EEPROM_24C04   = EEPROM24CxxType{"24C04", 512, 16, 1}
+	EEPROM_24C08   = EEPROM24CxxType{"24C08", 1024, 16, 1}
+	EEPROM_24C16   = EEPROM24CxxType{"24C16", 2048, 16, 1}
+	EEPROM_24C32   = EEPROM24CxxType{"24C32", 4096, 32, 2}
+	EEPROM_24C64   = EEPROM24CxxType{"24C64", 8192, 32, 2}
+	EEPROM_24C128  = EEPROM24CxxType{"24C128", 16384, 64, 2}
+	EEPROM_24C256  = EEPROM24CxxType{"24C256", 32768, 64, 2}
+	EEPROM_24C512  = EEPROM24CxxType{"24C512", 65536, 128, 2}
+	EEPROM_24C1024 = EEPROM24CxxType{"24C1024", 131072, 256, 2}
+)
+
+// blockSize returns the number of bytes addressable
+// without block-select bits.
+func (t *EEPROM24CxxType) blockSize() int {
+	return 1 << uint(8*t.AddressBytes)
+}
+
+// eepromMaxTransfer limits a single read message like the kernel does
+const eepromMaxTransfer = 8192
+
+// EEPROM24Cxx is a driver for the 24Cxx family of I2C EEPROMs.
+// It implements io.ReaderAt and io.WriterAt.
+type EEPROM24Cxx struct {
+	bus               I2CTransferer
+	address           int
+	eepromType        EEPROM24CxxType
+	writeCycleTimeout time.Duration
+}
+
+// NewEEPROM24Cxx returns a driver for the EEPROM of eepromType at address,
+// usually 0x50 to 0x57 depending on the address pins.
+// For EEPROMs with block-select bits the address must
+// have these bits cleared.
+func NewEEPROM24Cxx(bus I2CTransferer, address int, eepromType EEPROM24CxxType) (*EEPROM24Cxx, error) {
+	if eepromType.AddressBytes != 1 && eepromType.AddressBytes != 2 {
+		return nil, fmt.Errorf("Invalid EEPROM address size %d", eepromType.AddressBytes)
+	}
+	blocks := (eepromType.Size + eepromType.blockSize() - 1) / eepromType.blockSize()
+	if address < I2C_MIN_ADDRESS || address+blocks-1 > I2C_MAX_ADDRESS || address&(blocks-1) != 0 {
+		return nil, fmt.Errorf("Invalid address 0x%02X for %s EEPROM", address, eepromType.Name)
+	}
+	return &EEPROM24Cxx{
+		bus:               bus,
+		address:           address,
+		eepromType:        eepromType,
+		writeCycleTimeout: 25 * time.Millisecond,
+	}, nil
+}
+
+func (eeprom *EEPROM24Cxx) Type() EEPROM24CxxType {
+	return eeprom.eepromType
+}
+
+func (eeprom *EEPROM24Cxx) Size() int64 {
+	return int64(eeprom.eepromType.Size)
+}
+
+// SetWriteCycleTimeout sets how long to poll for the end
+// of the internal write cycle after writing a page.
+// Datasheets specify a maximum of 5 to 10 milliseconds,
+// the default is 25 milliseconds.
+func (eeprom *EEPROM24Cxx) SetWriteCycleTimeout(timeout time.Duration) {
+	eeprom.writeCycleTimeout = timeout
+}
+
+// message returns the device address and the memory address bytes for offset.
+func (eeprom *EEPROM24Cxx) message(offset int) (address int, memoryAddress []byte) {
+	blockSize := eeprom.eepromType.blockSize()
+	address = eeprom.address | offset/blockSize
+	offset %= blockSize
+	if eeprom.eepromType.AddressBytes == 2 {
+		return address, []byte{byte(offset >> 8), byte(offset)}
+	}
+	return address, []byte{byte(offset)}
+}
+
+// ReadAt implements io.ReaderAt.
+// Reads beyond the end of the EEPROM return io.EOF.
+func (eeprom *EEPROM24Cxx) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Negative EEPROM offset %d", off)
+	}
+	size := eeprom.eepromType.Size
+	if off >= int64(size) {
+		return 0, io.EOF
+	}
+	blockSize := eeprom.eepromType.blockSize()
+	for n < len(p) {
+		// off is less than size, so it fits into an int
+		offset := int(off) + n
+		if offset >= size {
+			return n, io.EOF
+		}
+		// Sequential reads wrap around at the end of a block
+		length := len(p) - n
+		if remaining := blockSize - offset%blockSize; length > remaining {
+			length = remaining
+		}
+		if remaining := size - offset; length > remaining {
+			length = remaining
+		}
+		if length > eepromMaxTransfer {
+			length = eepromMaxTransfer
+		}
+		address, memoryAddress := eeprom.message(offset)
+		err = eeprom.bus.Transfer([]I2CMessage{
+			{Address: address, Buf: memoryAddress},
+			{Address: address, Flags: I2C_MSG_READ, Buf: p[n : n+length]},
+		})
+		if err != nil {
+			return n, err
+		}
+		n += length
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt.
+// The data is written in chunks that don't cross page boundaries,
+// after each chunk WriteAt waits until the EEPROM
+// acknowledges its address again.
+func (eeprom *EEPROM24Cxx) WriteAt(p []byte, off int64) (n int, err error) {
+	size := eeprom.eepromType.Size
+	if off < 0 || off >= int64(size) {
+		return 0, fmt.Errorf("EEPROM offset %d not in the range 0 to %d", off, size-1)
+	}
+	pageSize := eeprom.eepromType.PageSize
+	if off+int64(len(p)) > int64(size) {
+		return 0, fmt.Errorf("Writing %d bytes at offset %d exceeds EEPROM size %d", len(p), off, size)
+	}
+	for n < len(p) {
+		offset := int(off) + n
+		length := pageSize - offset%pageSize
+		if length > len(p)-n {
+			length = len(p) - n
+		}
+		address, message := eeprom.message(offset)
+		message = append(message, p[n:n+length]...)
+		err = eeprom.bus.Transfer([]I2CMessage{{Address: address, Buf: message}})
+		if err != nil {
+			return n, err
+		}
+		err = eeprom.waitWriteCycle(address)
+		if err != nil {
+			return n, err
+		}
+		n += length
+	}
+	return n, nil
+}
+
+// waitWriteCycle polls the EEPROM until it acknowledges its address,
+// which it doesn't during the internal write cycle.
+// The memory address is sent as dummy write, because many adapters
+// don't support messages without data.
+func (eeprom *EEPROM24Cxx) waitWriteCycle(address int) error {
+	_, memoryAddress := eeprom.message(0)
+	deadline := time.Now().Add(eeprom.writeCycleTimeout)
+	for {
+		err := eeprom.bus.Transfer([]I2CMessage{{Address: address, Buf: memoryAddress}})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrI2CNAK) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("EEPROM write cycle timeout: %w", err)
+		}
+		time.Sleep(500 * time.Microsecond)
+	}
+}
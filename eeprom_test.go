@@ -0,0 +1,164 @@
+package bbio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// eepromFakeBus simulates a 24Cxx EEPROM with its address pointer,
+// page writes that wrap around within the page and
+// a write cycle that doesn't acknowledge busy polls.
+type eepromFakeBus struct {
+	eepromType EEPROM24CxxType
+	address    int
+	memory     []byte
+	pointer    int // offset within the block
+	busyPolls  int // polls to NAK after the next page write
+	busy       int
+	pageWrites int
+}
+
+func newEEPROMFakeBus(eepromType EEPROM24CxxType, address int) *eepromFakeBus {
+	return &eepromFakeBus{eepromType: eepromType, address: address, memory: make([]byte, eepromType.Size), busyPolls: 2}
+}
+
+func (bus *eepromFakeBus) Transfer(messages []I2CMessage) error {
+	blockSize := bus.eepromType.blockSize()
+	for _, message := range messages {
+		block := message.Address - bus.address
+		if bus.busy > 0 || block < 0 || block*blockSize >= bus.eepromType.Size {
+			if bus.busy > 0 {
+				bus.busy--
+			}
+			return ErrI2C{"Transfer", syscall.ENXIO}
+		}
+		memory := bus.memory[block*blockSize:]
+		if len(memory) > blockSize {
+			memory = memory[:blockSize]
+		}
+		if message.Flags&I2C_MSG_READ != 0 {
+			for i := range message.Buf {
+				message.Buf[i] = memory[bus.pointer]
+				bus.pointer = (bus.pointer + 1) % len(memory)
+			}
+			continue
+		}
+		bus.pointer = 0
+		for _, b := range message.Buf[:bus.eepromType.AddressBytes] {
+			bus.pointer = bus.pointer<<8 | int(b)
+		}
+		data := message.Buf[bus.eepromType.AddressBytes:]
+		if len(data) == 0 {
+			continue
+		}
+		pageSize := bus.eepromType.PageSize
+		page := bus.pointer - bus.pointer%pageSize
+		for i, b := range data {
+			memory[page+(bus.pointer+i)%pageSize] = b
+		}
+		bus.pageWrites++
+		bus.busy = bus.busyPolls
+	}
+	return nil
+}
+
+func eepromTestData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + 1)
+	}
+	return data
+}
+
+func TestEEPROM24Cxx(t *testing.T) {
+	for _, eepromType := range []EEPROM24CxxType{EEPROM_24C02, EEPROM_24C16, EEPROM_24C256} {
+		bus := newEEPROMFakeBus(eepromType, 0x50)
+		eeprom, err := NewEEPROM24Cxx(bus, 0x50, eepromType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Crosses pages and for the 24C16 a block
+		offset := int64(250)
+		data := eepromTestData(100)
+		if eepromType.Size < 512 {
+			offset = 5
+		}
+		n, err := eeprom.WriteAt(data, offset)
+		if n != len(data) || err != nil {
+			t.Fatalf("%s: WriteAt returned %d, %v", eepromType.Name, n, err)
+		}
+		if !bytes.Equal(bus.memory[offset:offset+100], data) {
+			t.Errorf("%s: memory % X", eepromType.Name, bus.memory[offset:offset+100])
+		}
+		if minWrites := 100 / eepromType.PageSize; bus.pageWrites <= minWrites {
+			t.Errorf("%s: %d page writes", eepromType.Name, bus.pageWrites)
+		}
+
+		read := make([]byte, 100)
+		n, err = eeprom.ReadAt(read, offset)
+		if n != len(read) || err != nil || !bytes.Equal(read, data) {
+			t.Errorf("%s: ReadAt returned %d, %v, % X", eepromType.Name, n, err, read)
+		}
+
+		n, err = eeprom.ReadAt(read, eeprom.Size()-10)
+		if n != 10 || err != io.EOF {
+			t.Errorf("%s: ReadAt at end returned %d, %v", eepromType.Name, n, err)
+		}
+		if _, err = eeprom.WriteAt(data, eeprom.Size()-10); err == nil {
+			t.Errorf("%s: expected error for writing beyond the end", eepromType.Name)
+		}
+		if _, err = eeprom.ReadAt(read, -1); err == nil {
+			t.Errorf("%s: expected error for negative offset", eepromType.Name)
+		}
+		// Offsets that would be truncated to a valid int on 32 bit systems
+		for _, offset := range []int64{eeprom.Size(), 1 << 32, 1<<32 + 16, math.MaxInt64} {
+			if n, err = eeprom.ReadAt(read, offset); n != 0 || err != io.EOF {
+				t.Errorf("%s: ReadAt at offset %d returned %d, %v", eepromType.Name, offset, n, err)
+			}
+			if n, err = eeprom.WriteAt([]byte{1}, offset); n != 0 || err == nil {
+				t.Errorf("%s: WriteAt at offset %d returned %d, %v", eepromType.Name, offset, n, err)
+			}
+		}
+		if n, err = eeprom.WriteAt([]byte{1}, -1); n != 0 || err == nil {
+			t.Errorf("%s: WriteAt at negative offset returned %d, %v", eepromType.Name, n, err)
+		}
+	}
+}
+
+func TestEEPROM24CxxWriteCycleTimeout(t *testing.T) {
+	bus := newEEPROMFakeBus(EEPROM_24C32, 0x50)
+	bus.busyPolls = 1000
+	eeprom, _ := NewEEPROM24Cxx(bus, 0x50, EEPROM_24C32)
+	eeprom.SetWriteCycleTimeout(2 * time.Millisecond)
+	n, err := eeprom.WriteAt([]byte{1, 2}, 0)
+	if n != 0 || !errors.Is(err, ErrI2CNAK) {
+		t.Errorf("WriteAt returned %d, %v", n, err)
+	}
+}
+
+func TestNewEEPROM24Cxx(t *testing.T) {
+	tests := []struct {
+		eepromType EEPROM24CxxType
+		address    int
+		valid      bool
+	}{
+		{EEPROM_24C02, 0x57, true},
+		{EEPROM_24C16, 0x50, true},
+		{EEPROM_24C16, 0x51, false}, // block-select bits set
+		{EEPROM_24C1024, 0x56, true},
+		{EEPROM_24C1024, 0x77, false}, // second block beyond the address range
+		{EEPROM_24C02, 0x03, false},
+		{EEPROM24CxxType{"24C3", 256, 8, 3}, 0x50, false},
+	}
+	for _, test := range tests {
+		_, err := NewEEPROM24Cxx(newEEPROMFakeBus(test.eepromType, test.address), test.address, test.eepromType)
+		if (err == nil) != test.valid {
+			t.Errorf("%s at 0x%02X returned %v", test.eepromType.Name, test.address, err)
+		}
+	}
+}
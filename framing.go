@@ -0,0 +1,335 @@
+package bbio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrPacketCorrupt is returned by ReadPacket for a damaged packet.
+	// The framer is synchronized again for the next ReadPacket.
+	ErrPacketCorrupt = errors.New("corrupt packet")
+	// ErrPacketTooLong is returned by ReadPacket for a packet
+	// longer than the maximum size of the framer.
+	// The framer is synchronized again for the next ReadPacket.
+	ErrPacketTooLong = errors.New("packet too long")
+)
+
+// PacketFramer sends and receives whole packets
+// over a byte stream like an UART.
+// If ReadPacket returns a read timeout error, see IsTimeout,
+// the partially received packet is kept and
+// ReadPacket can be called again to continue it.
+type PacketFramer interface {
+	WritePacket(packet []byte) error
+	ReadPacket() ([]byte, error)
+}
+
+func checkPacketSize(packet []byte, maxSize int) error {
+	if len(packet) > maxSize {
+		return fmt.Errorf("Packet size %d exceeds maximum of %d", len(packet), maxSize)
+	}
+	return nil
+}
+
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+// SLIPFramer implements PacketFramer with the
+// Serial Line Internet Protocol framing of RFC 1055.
+type SLIPFramer struct {
+	w       io.Writer
+	r       *bufio.Reader
+	maxSize int
+
+	// State of the packet being received
+	packet    []byte
+	packetErr error
+	escaped   bool
+}
+
+func NewSLIPFramer(rw io.ReadWriter, maxSize int) *SLIPFramer {
+	return &SLIPFramer{w: rw, r: bufio.NewReader(rw), maxSize: maxSize}
+}
+
+func (f *SLIPFramer) WritePacket(packet []byte) error {
+	if err := checkPacketSize(packet, f.maxSize); err != nil {
+		return err
+	}
+	// The leading END terminates any line noise as separate packet
+	frame := make([]byte, 0, len(packet)*2+2)
+	frame = append(frame, slipEnd)
+	for _, b := range packet {
+		switch b {
+		case slipEnd:
+			frame = append(frame, slipEsc, slipEscEnd)
+		case slipEsc:
+			frame = append(frame, slipEsc, slipEscEsc)
+		default:
+			frame = append(frame, b)
+		}
+	}
+	frame = append(frame, slipEnd)
+	_, err := f.w.Write(frame)
+	return err
+}
+
+// ReadPacket returns the next non empty packet.
+func (f *SLIPFramer) ReadPacket() ([]byte, error) {
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b == slipEnd:
+			packet, packetErr, escaped := f.packet, f.packetErr, f.escaped
+			f.packet, f.packetErr, f.escaped = nil, nil, false
+			if packetErr != nil {
+				return nil, packetErr
+			}
+			if escaped {
+				return nil, ErrPacketCorrupt
+			}
+			if len(packet) > 0 {
+				return packet, nil
+			}
+			continue
+		case f.packetErr != nil:
+			// Skip until the next END
+			continue
+		case f.escaped:
+			f.escaped = false
+			switch b {
+			case slipEscEnd:
+				b = slipEnd
+			case slipEscEsc:
+				b = slipEsc
+			default:
+				f.packetErr = ErrPacketCorrupt
+				continue
+			}
+		case b == slipEsc:
+			f.escaped = true
+			continue
+		}
+		if len(f.packet) == f.maxSize {
+			f.packetErr = ErrPacketTooLong
+			continue
+		}
+		f.packet = append(f.packet, b)
+	}
+}
+
+// COBSFramer implements PacketFramer with
+// Consistent Overhead Byte Stuffing and zero bytes as delimiter.
+type COBSFramer struct {
+	w       io.Writer
+	r       *bufio.Reader
+	maxSize int
+
+	// State of the packet being received
+	encoded []byte
+	tooLong bool
+}
+
+func NewCOBSFramer(rw io.ReadWriter, maxSize int) *COBSFramer {
+	return &COBSFramer{w: rw, r: bufio.NewReader(rw), maxSize: maxSize}
+}
+
+// COBSEncode returns data encoded without zero bytes
+// and without the trailing zero delimiter.
+func COBSEncode(data []byte) []byte {
+	encoded := make([]byte, 1, len(data)+len(data)/254+2)
+	codeIndex := 0
+	code := byte(1)
+	for i, b := range data {
+		if b != 0 {
+			encoded = append(encoded, b)
+			code++
+		}
+		if b == 0 || code == 0xFF {
+			encoded[codeIndex] = code
+			if b != 0 && i == len(data)-1 {
+				// A full group at the end needs no empty group after it
+				return encoded
+			}
+			codeIndex = len(encoded)
+			encoded = append(encoded, 0)
+			code = 1
+		}
+	}
+	encoded[codeIndex] = code
+	return encoded
+}
+
+// COBSDecode decodes data encoded by COBSEncode
+// without the trailing zero delimiter.
+func COBSDecode(encoded []byte) ([]byte, error) {
+	data := make([]byte, 0, len(encoded))
+	for i := 0; i < len(encoded); {
+		code := int(encoded[i])
+		if code == 0 || i+code > len(encoded) {
+			return nil, ErrPacketCorrupt
+		}
+		for _, b := range encoded[i+1 : i+code] {
+			if b == 0 {
+				return nil, ErrPacketCorrupt
+			}
+		}
+		data = append(data, encoded[i+1:i+code]...)
+		i += code
+		if code < 0xFF && i < len(encoded) {
+			data = append(data, 0)
+		}
+	}
+	return data, nil
+}
+
+func (f *COBSFramer) WritePacket(packet []byte) error {
+	if err := checkPacketSize(packet, f.maxSize); err != nil {
+		return err
+	}
+	_, err := f.w.Write(append(COBSEncode(packet), 0))
+	return err
+}
+
+// ReadPacket returns the next non empty packet.
+func (f *COBSFramer) ReadPacket() ([]byte, error) {
+	// Maximum encoded size including one overhead byte per 254 bytes
+	maxEncoded := f.maxSize + f.maxSize/254 + 1
+	for {
+		b, err := f.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != 0 {
+			if len(f.encoded) == maxEncoded {
+				f.tooLong = true
+			} else {
+				f.encoded = append(f.encoded, b)
+			}
+			continue
+		}
+		encoded, tooLong := f.encoded, f.tooLong
+		f.encoded, f.tooLong = nil, false
+		if tooLong {
+			return nil, ErrPacketTooLong
+		}
+		if len(encoded) == 0 {
+			continue
+		}
+		packet, err := COBSDecode(encoded)
+		if err != nil {
+			return nil, err
+		}
+		if len(packet) > f.maxSize {
+			return nil, ErrPacketTooLong
+		}
+		return packet, nil
+	}
+}
+
+// LengthCRCFramer implements PacketFramer with frames of
+// two sync bytes 0xAA 0x55, the little endian uint16 length of the packet,
+// the packet and the little endian CRC-16/CCITT of length and packet.
+// After a corrupt frame the reader searches for the next sync bytes
+// starting directly after the sync bytes of the corrupt frame.
+type LengthCRCFramer struct {
+	w       io.Writer
+	r       *bufio.Reader
+	maxSize int
+}
+
+const (
+	lengthCRCSync0      = 0xAA
+	lengthCRCSync1      = 0x55
+	lengthCRCHeaderSize = 4
+)
+
+// NewLengthCRCFramer returns a LengthCRCFramer for packets
+// of up to maxSize bytes, which can't be larger than 65535.
+func NewLengthCRCFramer(rw io.ReadWriter, maxSize int) *LengthCRCFramer {
+	if maxSize > 0xFFFF {
+		maxSize = 0xFFFF
+	}
+	return &LengthCRCFramer{
+		w:       rw,
+		r:       bufio.NewReaderSize(rw, lengthCRCHeaderSize+maxSize+2),
+		maxSize: maxSize,
+	}
+}
+
+// CRC16CCITT returns the CRC-16/CCITT-FALSE checksum of data.
+func CRC16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func (f *LengthCRCFramer) WritePacket(packet []byte) error {
+	if err := checkPacketSize(packet, f.maxSize); err != nil {
+		return err
+	}
+	frame := make([]byte, 0, lengthCRCHeaderSize+len(packet)+2)
+	frame = append(frame, lengthCRCSync0, lengthCRCSync1, byte(len(packet)), byte(len(packet)>>8))
+	frame = append(frame, packet...)
+	crc := CRC16CCITT(frame[2:])
+	frame = append(frame, byte(crc), byte(crc>>8))
+	_, err := f.w.Write(frame)
+	return err
+}
+
+// ReadPacket returns the next packet.
+// Corrupt frames are skipped, so no ErrPacketCorrupt is returned.
+func (f *LengthCRCFramer) ReadPacket() ([]byte, error) {
+	for {
+		// Bytes are only discarded after they are known to be no valid frame,
+		// so that a read error like a timeout doesn't lose a partial frame
+		sync, err := f.r.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if sync[0] != lengthCRCSync0 || sync[1] != lengthCRCSync1 {
+			f.r.Discard(1)
+			continue
+		}
+
+		header, err := f.r.Peek(lengthCRCHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+		length := int(header[2]) | int(header[3])<<8
+		if length > f.maxSize {
+			f.r.Discard(1)
+			continue
+		}
+		frame, err := f.r.Peek(lengthCRCHeaderSize + length + 2)
+		if err != nil {
+			return nil, err
+		}
+		crc := CRC16CCITT(frame[2 : len(frame)-2])
+		if frame[len(frame)-2] != byte(crc) || frame[len(frame)-1] != byte(crc>>8) {
+			f.r.Discard(1)
+			continue
+		}
+		packet := append([]byte(nil), frame[lengthCRCHeaderSize:len(frame)-2]...)
+		f.r.Discard(len(frame))
+		return packet, nil
+	}
+}
@@ -0,0 +1,181 @@
+package bbio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// chunkReader returns one chunk per Read with a read timeout
+// between the chunks, and io.EOF after the last chunk.
+type chunkReader struct {
+	chunks  [][]byte
+	timeout bool
+}
+
+func newChunkReader(chunks ...[]byte) *chunkReader {
+	return &chunkReader{chunks: chunks}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.timeout {
+		r.timeout = false
+		return 0, &os.PathError{Op: "read", Path: "/dev/ttyS1", Err: os.ErrDeadlineExceeded}
+	}
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.chunks[0])
+	r.chunks[0] = r.chunks[0][n:]
+	if len(r.chunks[0]) == 0 {
+		r.chunks = r.chunks[1:]
+		r.timeout = true
+	}
+	return n, nil
+}
+
+func (r *chunkReader) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// readPacketsAfterTimeouts reads packets until io.EOF
+// and calls ReadPacket again after every timeout.
+func readPacketsAfterTimeouts(t *testing.T, framer PacketFramer) (packets [][]byte, errs []error) {
+	t.Helper()
+	for {
+		packet, err := framer.ReadPacket()
+		switch {
+		case err == io.EOF:
+			return packets, errs
+		case IsTimeout(err):
+			continue
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			packets = append(packets, packet)
+		}
+	}
+}
+
+func TestCRC16CCITT(t *testing.T) {
+	if crc := CRC16CCITT([]byte("123456789")); crc != 0x29B1 {
+		t.Errorf("CRC of check string is 0x%04X instead of 0x29B1", crc)
+	}
+}
+
+func TestCOBS(t *testing.T) {
+	run254 := make([]byte, 254)
+	for i := range run254 {
+		run254[i] = byte(i + 1)
+	}
+	tests := []struct {
+		data    []byte
+		encoded []byte
+	}{
+		{[]byte{}, []byte{0x01}},
+		{[]byte{0x00}, []byte{0x01, 0x01}},
+		{[]byte{0x00, 0x00}, []byte{0x01, 0x01, 0x01}},
+		{[]byte{0x11, 0x22, 0x00, 0x33}, []byte{0x03, 0x11, 0x22, 0x02, 0x33}},
+		{[]byte{0x11, 0x22, 0x33, 0x44}, []byte{0x05, 0x11, 0x22, 0x33, 0x44}},
+		{[]byte{0x11, 0x00, 0x00, 0x00}, []byte{0x02, 0x11, 0x01, 0x01, 0x01}},
+		{run254, append([]byte{0xFF}, run254...)},
+		{append(run254, 0x00), append(append([]byte{0xFF}, run254...), 0x01, 0x01)},
+		{append(run254, 0xFF), append(append([]byte{0xFF}, run254...), 0x02, 0xFF)},
+	}
+	for _, test := range tests {
+		encoded := COBSEncode(test.data)
+		if !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("COBSEncode(% X) = % X instead of % X", test.data, encoded, test.encoded)
+		}
+		decoded, err := COBSDecode(test.encoded)
+		if err != nil || !bytes.Equal(decoded, test.data) {
+			t.Errorf("COBSDecode(% X) = % X, %v", test.encoded, decoded, err)
+		}
+	}
+
+	for _, encoded := range [][]byte{{0x00}, {0x03, 0x11}, {0x03, 0x11, 0x00}} {
+		if _, err := COBSDecode(encoded); err != ErrPacketCorrupt {
+			t.Errorf("COBSDecode(% X) returned %v instead of ErrPacketCorrupt", encoded, err)
+		}
+	}
+}
+
+func TestCOBSFramer(t *testing.T) {
+	var stream bytes.Buffer
+	writer := NewCOBSFramer(&stream, 16)
+	for _, packet := range [][]byte{{1, 0, 2}, {0}, make([]byte, 17)} {
+		writer.WritePacket(packet)
+	}
+	if stream.Len() != 5+3 {
+		t.Fatalf("stream % X", stream.Bytes())
+	}
+	data := stream.Bytes()
+	// Split within the first packet and add a too long packet
+	long := append(bytes.Repeat([]byte{1}, 20), 0)
+	framer := NewCOBSFramer(newChunkReader(data[:2], data[2:], long), 16)
+	packets, errs := readPacketsAfterTimeouts(t, framer)
+	if len(packets) != 2 || !bytes.Equal(packets[0], []byte{1, 0, 2}) || !bytes.Equal(packets[1], []byte{0}) {
+		t.Errorf("packets % X", packets)
+	}
+	if len(errs) != 1 || errs[0] != ErrPacketTooLong {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+func TestSLIPFramer(t *testing.T) {
+	var stream bytes.Buffer
+	writer := NewSLIPFramer(&stream, 8)
+	packet := []byte{1, slipEnd, 2, slipEsc, 3}
+	writer.WritePacket(packet)
+	expected := []byte{slipEnd, 1, slipEsc, slipEscEnd, 2, slipEsc, slipEscEsc, 3, slipEnd}
+	if !bytes.Equal(stream.Bytes(), expected) {
+		t.Fatalf("SLIP frame % X instead of % X", stream.Bytes(), expected)
+	}
+	if writer.WritePacket(make([]byte, 9)) == nil {
+		t.Error("expected error for too long packet")
+	}
+
+	data := stream.Bytes()
+	corrupt := []byte{slipEnd, 1, slipEsc, 5, slipEnd}
+	long := append(bytes.Repeat([]byte{1}, 9), slipEnd)
+	// Split between escape and escaped byte
+	framer := NewSLIPFramer(newChunkReader(data[:3], data[3:], corrupt, long, data), 8)
+	packets, errs := readPacketsAfterTimeouts(t, framer)
+	if len(packets) != 2 || !bytes.Equal(packets[0], packet) || !bytes.Equal(packets[1], packet) {
+		t.Errorf("packets % X", packets)
+	}
+	if len(errs) != 2 || errs[0] != ErrPacketCorrupt || errs[1] != ErrPacketTooLong {
+		t.Errorf("errors %v", errs)
+	}
+}
+
+func TestLengthCRCFramer(t *testing.T) {
+	var stream bytes.Buffer
+	writer := NewLengthCRCFramer(&stream, 32)
+	writer.WritePacket([]byte{1, 2, 3, 4, 5})
+	frame := append([]byte(nil), stream.Bytes()...)
+	if !bytes.Equal(frame[:4], []byte{0xAA, 0x55, 5, 0}) {
+		t.Fatalf("frame % X", frame)
+	}
+
+	corrupt := append([]byte(nil), frame...)
+	corrupt[5]++
+	tests := []struct {
+		name   string
+		chunks [][]byte
+	}{
+		{"split after first sync byte", [][]byte{frame[:1], frame[1:]}},
+		{"split in header", [][]byte{frame[:3], frame[3:]}},
+		{"split in packet", [][]byte{append([]byte{0x12, 0xAA}, frame[:6]...), frame[6:]}},
+		{"after corrupt frame", [][]byte{corrupt[:4], corrupt[4:], frame}},
+		{"after too long frame", [][]byte{{0xAA, 0x55, 0xFF, 0xFF}, frame}},
+	}
+	for _, test := range tests {
+		framer := NewLengthCRCFramer(newChunkReader(test.chunks...), 32)
+		packets, errs := readPacketsAfterTimeouts(t, framer)
+		if len(errs) != 0 || len(packets) != 1 || !bytes.Equal(packets[0], []byte{1, 2, 3, 4, 5}) {
+			t.Errorf("%s: packets % X, errors %v", test.name, packets, errs)
+		}
+	}
+}
@@ -42,6 +42,22 @@ const (
 	GPIO_PUD_UP   GPIOPullUpDown = 2
 )
 
+// DigitalPin is implemented by GPIO and ExpanderPin,
+// so code can use pins of I/O expanders instead of native pins.
+type DigitalPin interface {
+	Direction() (GPIODirection, error)
+	SetDirection(direction GPIODirection) error
+	Value() (bool, error)
+	SetValue(value bool) error
+	AddEdgeDetect(edge GPIOEdge) (chan bool, error)
+	RemoveEdgeDetect()
+	BlockingWaitForEdge(edge GPIOEdge) (bool, error)
+	Close() error
+}
+
+// gpioSysfsDir is the sysfs directory of the GPIO pins.
+var gpioSysfsDir = "/sys/class/gpio"
+
 type GPIO struct {
 	nr    int
 	value *os.File
@@ -56,7 +72,7 @@ func NewGPIO(nameOrKey string) (*GPIO, error) {
 	}
 	gpio := &GPIO{nr: pin.GPIO}
 
-	export, err := os.OpenFile("/sys/class/gpio/export", os.O_WRONLY, 0666)
+	export, err := os.OpenFile(gpioSysfsDir+"/export", os.O_WRONLY, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +94,7 @@ func (gpio *GPIO) Close() error {
 		gpio.value.Close()
 	}
 
-	unexport, err := os.OpenFile("/sys/class/gpio/unexport", os.O_WRONLY, 0666)
+	unexport, err := os.OpenFile(gpioSysfsDir+"/unexport", os.O_WRONLY, 0666)
 	if err != nil {
 		return err
 	}
@@ -89,7 +105,7 @@ func (gpio *GPIO) Close() error {
 }
 
 func (gpio *GPIO) Direction() (GPIODirection, error) {
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.nr)
+	filename := fmt.Sprintf("%s/gpio%d/direction", gpioSysfsDir, gpio.nr)
 	file, err := os.OpenFile(filename, os.O_RDONLY|syscall.O_NONBLOCK, 0666)
 	if err != nil {
 		return "", err
@@ -108,7 +124,7 @@ func (gpio *GPIO) Direction() (GPIODirection, error) {
 }
 
 func (gpio *GPIO) SetDirection(direction GPIODirection) error {
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.nr)
+	filename := fmt.Sprintf("%s/gpio%d/direction", gpioSysfsDir, gpio.nr)
 	file, err := os.OpenFile(filename, os.O_WRONLY, 0666)
 	if err != nil {
 		return err
@@ -122,7 +138,7 @@ func (gpio *GPIO) openValueFile() error {
 	if gpio.value != nil {
 		return nil
 	}
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/value", gpio.nr)
+	filename := fmt.Sprintf("%s/gpio%d/value", gpioSysfsDir, gpio.nr)
 	file, err := os.OpenFile(filename, os.O_RDWR, 0666)
 	if err == nil {
 		gpio.value = file
@@ -157,7 +173,7 @@ func (gpio *GPIO) SetValue(value bool) (err error) {
 }
 
 func (gpio *GPIO) SetEdge(edge GPIOEdge) error {
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/edge", gpio.nr)
+	filename := fmt.Sprintf("%s/gpio%d/edge", gpioSysfsDir, gpio.nr)
 	file, err := os.OpenFile(filename, os.O_WRONLY, 0666)
 	if err != nil {
 		return err
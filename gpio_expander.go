@@ -0,0 +1,541 @@
+package bbio
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	_ DigitalPin = (*GPIO)(nil)
+	_ DigitalPin = (*ExpanderPin)(nil)
+)
+
+// gpioExpanderChip implements the register access of an I/O expander.
+// Pin masks have bit n set for pin n.
+type gpioExpanderChip interface {
+	name() string
+	numPins() int
+	// init configures the chip and returns the current configuration
+	init() (inputs, pullUps, outputs uint16, err error)
+	setInputs(inputs uint16) error
+	setPullUps(pullUps uint16) error
+	writeOutputs(outputs uint16) error
+	readPins() (uint16, error)
+	setInterrupts(enabled uint16) error
+}
+
+// GPIOExpander is an I2C I/O expander like the MCP23017, MCP23008 or PCF8574.
+// Its pins implement DigitalPin, so they can be used instead of GPIO.
+// Edge detection needs the interrupt output of the expander
+// connected to a native GPIO, see SetInterruptGPIO.
+type GPIOExpander struct {
+	chip gpioExpanderChip
+
+	mutex   sync.Mutex
+	inputs  uint16
+	pullUps uint16
+	outputs uint16
+	pins    []*ExpanderPin
+
+	interrupt *GPIO
+	last      uint16 // pin values at the last interrupt
+	stop      chan struct{}
+}
+
+func newGPIOExpander(chip gpioExpanderChip) (*GPIOExpander, error) {
+	inputs, pullUps, outputs, err := chip.init()
+	if err != nil {
+		return nil, fmt.Errorf("%s init: %w", chip.name(), err)
+	}
+	expander := &GPIOExpander{
+		chip:    chip,
+		inputs:  inputs,
+		pullUps: pullUps,
+		outputs: outputs,
+		pins:    make([]*ExpanderPin, chip.numPins()),
+	}
+	for i := range expander.pins {
+		expander.pins[i] = &ExpanderPin{expander: expander, nr: i}
+	}
+	return expander, nil
+}
+
+func (expander *GPIOExpander) Name() string {
+	return expander.chip.name()
+}
+
+func (expander *GPIOExpander) NumPins() int {
+	return len(expander.pins)
+}
+
+// Pin returns the pin nr, counted from zero.
+// For the MCP23017 pins 0 to 7 are GPA0 to GPA7
+// and pins 8 to 15 are GPB0 to GPB7.
+func (expander *GPIOExpander) Pin(nr int) (*ExpanderPin, error) {
+	if nr < 0 || nr >= len(expander.pins) {
+		return nil, fmt.Errorf("%s has no pin %d", expander.chip.name(), nr)
+	}
+	return expander.pins[nr], nil
+}
+
+// ReadAll returns the values of all pins as bitmask.
+func (expander *GPIOExpander) ReadAll() (uint16, error) {
+	return expander.chip.readPins()
+}
+
+// SetInterruptGPIO starts edge detection with the interrupt output
+// of the expander connected to gpio. The interrupt output is active low,
+// so gpio is configured as input with falling edge detection.
+// Pins of the expander are read after every interrupt
+// and changes are sent to the channels of ExpanderPin.AddEdgeDetect.
+func (expander *GPIOExpander) SetInterruptGPIO(gpio *GPIO) error {
+	expander.stopInterrupt()
+
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	edges, err := gpio.AddEdgeDetect(GPIO_FALLING_EDGE)
+	if err != nil {
+		return err
+	}
+	// Reading the pins also clears a pending interrupt
+	last, err := expander.chip.readPins()
+	if err != nil {
+		gpio.RemoveEdgeDetect()
+		return err
+	}
+	expander.interrupt = gpio
+	expander.last = last
+	expander.stop = make(chan struct{})
+	go expander.handleInterrupts(edges, expander.stop)
+	return nil
+}
+
+func (expander *GPIOExpander) stopInterrupt() {
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	if expander.interrupt == nil {
+		return
+	}
+	close(expander.stop)
+	expander.interrupt.RemoveEdgeDetect()
+	expander.interrupt = nil
+}
+
+func (expander *GPIOExpander) handleInterrupts(edges chan bool, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-edges:
+		}
+		values, err := expander.chip.readPins()
+		if err != nil {
+			continue
+		}
+
+		expander.mutex.Lock()
+		changed := values ^ expander.last
+		expander.last = values
+		for _, pin := range expander.pins {
+			mask := uint16(1) << uint(pin.nr)
+			if changed&mask == 0 || pin.events == nil {
+				continue
+			}
+			value := values&mask != 0
+			if pin.edge == GPIO_BOTH_EDGE ||
+				(pin.edge == GPIO_RISING_EDGE && value) ||
+				(pin.edge == GPIO_FALLING_EDGE && !value) {
+				// Don't block the other pins if nobody reads the events
+				select {
+				case pin.events <- value:
+				default:
+				}
+			}
+		}
+		expander.mutex.Unlock()
+	}
+}
+
+// updateInterrupts enables the interrupts of all pins with edge detection.
+func (expander *GPIOExpander) updateInterrupts() error {
+	var enabled uint16
+	for _, pin := range expander.pins {
+		if pin.events != nil {
+			enabled |= 1 << uint(pin.nr)
+		}
+	}
+	return expander.chip.setInterrupts(enabled)
+}
+
+// Close stops the edge detection, the interrupt GPIO is not closed.
+func (expander *GPIOExpander) Close() error {
+	expander.stopInterrupt()
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	for _, pin := range expander.pins {
+		pin.events = nil
+	}
+	return expander.updateInterrupts()
+}
+
+// ExpanderPin is a pin of a GPIOExpander and implements DigitalPin.
+type ExpanderPin struct {
+	expander *GPIOExpander
+	nr       int
+	edge     GPIOEdge
+	events   chan bool
+}
+
+func (pin *ExpanderPin) Expander() *GPIOExpander {
+	return pin.expander
+}
+
+func (pin *ExpanderPin) Nr() int {
+	return pin.nr
+}
+
+func (pin *ExpanderPin) mask() uint16 {
+	return 1 << uint(pin.nr)
+}
+
+func (pin *ExpanderPin) Direction() (GPIODirection, error) {
+	pin.expander.mutex.Lock()
+	defer pin.expander.mutex.Unlock()
+	if pin.expander.inputs&pin.mask() != 0 {
+		return GPIO_INPUT, nil
+	}
+	return GPIO_OUTPUT, nil
+}
+
+func (pin *ExpanderPin) SetDirection(direction GPIODirection) error {
+	pin.expander.mutex.Lock()
+	defer pin.expander.mutex.Unlock()
+	return pin.setDirection(direction)
+}
+
+func (pin *ExpanderPin) setDirection(direction GPIODirection) error {
+	expander := pin.expander
+	inputs := expander.inputs
+	switch direction {
+	case GPIO_INPUT:
+		inputs |= pin.mask()
+	case GPIO_OUTPUT:
+		inputs &^= pin.mask()
+	default:
+		return fmt.Errorf("Invalid GPIO direction '%s'", direction)
+	}
+	err := expander.chip.setInputs(inputs)
+	if err != nil {
+		return err
+	}
+	expander.inputs = inputs
+	return nil
+}
+
+// Value returns the level of the pin, for outputs too.
+func (pin *ExpanderPin) Value() (bool, error) {
+	values, err := pin.expander.chip.readPins()
+	if err != nil {
+		return false, err
+	}
+	return values&pin.mask() != 0, nil
+}
+
+func (pin *ExpanderPin) SetValue(value bool) error {
+	expander := pin.expander
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	outputs := expander.outputs &^ pin.mask()
+	if value {
+		outputs |= pin.mask()
+	}
+	err := expander.chip.writeOutputs(outputs)
+	if err != nil {
+		return err
+	}
+	expander.outputs = outputs
+	return nil
+}
+
+// SetPullUpDown configures the internal pull-up of an input.
+// The expanders have no pull-downs.
+func (pin *ExpanderPin) SetPullUpDown(pud GPIOPullUpDown) error {
+	expander := pin.expander
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	pullUps := expander.pullUps
+	switch pud {
+	case GPIO_PUD_UP:
+		pullUps |= pin.mask()
+	case GPIO_PUD_OFF:
+		pullUps &^= pin.mask()
+	case GPIO_PUD_DOWN:
+		return fmt.Errorf("%s has no pull-down resistors", expander.chip.name())
+	default:
+		return fmt.Errorf("Invalid GPIO pull-up/down %d", pud)
+	}
+	err := expander.chip.setPullUps(pullUps)
+	if err != nil {
+		return err
+	}
+	expander.pullUps = pullUps
+	return nil
+}
+
+// AddEdgeDetect configures the pin as input and returns a channel
+// that receives the new value after every edge.
+// Edges are only detected after SetInterruptGPIO of the expander.
+// If the channel is not read, further edges are dropped.
+func (pin *ExpanderPin) AddEdgeDetect(edge GPIOEdge) (chan bool, error) {
+	pin.RemoveEdgeDetect()
+	if edge == GPIO_NO_EDGE {
+		return nil, errors.New("No edge to detect")
+	}
+
+	expander := pin.expander
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	if expander.interrupt == nil {
+		return nil, fmt.Errorf("%s has no interrupt GPIO for edge detection", expander.chip.name())
+	}
+	err := pin.setDirection(GPIO_INPUT)
+	if err != nil {
+		return nil, err
+	}
+	// Changes before the interrupt was enabled are no edges
+	values, err := expander.chip.readPins()
+	if err != nil {
+		return nil, err
+	}
+	expander.last = expander.last&^pin.mask() | values&pin.mask()
+	pin.edge = edge
+	pin.events = make(chan bool, 16)
+	err = expander.updateInterrupts()
+	if err != nil {
+		pin.events = nil
+		return nil, err
+	}
+	return pin.events, nil
+}
+
+func (pin *ExpanderPin) RemoveEdgeDetect() {
+	expander := pin.expander
+	expander.mutex.Lock()
+	defer expander.mutex.Unlock()
+	if pin.events == nil {
+		return
+	}
+	pin.events = nil
+	pin.edge = GPIO_NO_EDGE
+	expander.updateInterrupts()
+}
+
+func (pin *ExpanderPin) BlockingWaitForEdge(edge GPIOEdge) (value bool, err error) {
+	events, err := pin.AddEdgeDetect(edge)
+	if err == nil {
+		value = <-events
+		pin.RemoveEdgeDetect()
+	}
+	return value, err
+}
+
+// Close removes the edge detection of the pin.
+func (pin *ExpanderPin) Close() error {
+	pin.RemoveEdgeDetect()
+	return nil
+}
+
+// mcp23xxx implements the MCP23017 and MCP23008.
+// With IOCON.BANK = 0 the registers of the MCP23017
+// for port A and B are pairs that are accessed as one little endian word.
+type mcp23xxx struct {
+	chipName string
+	pins     int
+	regs     *RegMap
+	iodir    *Register
+	gpinten  *Register
+	intcon   *Register
+	iocon    *Register
+	gppu     *Register
+	gpio     *Register
+	olat     *Register
+}
+
+const (
+	mcpIOCONMirror = 0x40 // INTA and INTB are connected
+	mcpIOCONSeqOp  = 0x20 // Disables the address pointer increment
+)
+
+func newMCP23xxx(dev I2CTxer, chipName string, pins int, addresses [7]uint16) (*mcp23xxx, error) {
+	bus, err := NewI2CRegMapBus(dev, 1)
+	if err != nil {
+		return nil, err
+	}
+	width := pins / 8
+	reg := func(name string, address uint16) *Register {
+		return &Register{Name: name, Address: address, Width: width, LittleEndian: true}
+	}
+	return &mcp23xxx{
+		chipName: chipName,
+		pins:     pins,
+		regs:     NewRegMap(bus),
+		iodir:    reg("IODIR", addresses[0]),
+		gpinten:  reg("GPINTEN", addresses[1]),
+		intcon:   reg("INTCON", addresses[2]),
+		iocon:    &Register{Name: "IOCON", Address: addresses[3]},
+		gppu:     reg("GPPU", addresses[4]),
+		gpio:     &Register{Name: "GPIO", Address: addresses[5], Width: width, LittleEndian: true, Access: REG_READ_ONLY},
+		olat:     reg("OLAT", addresses[6]),
+	}, nil
+}
+
+// NewMCP23017 returns a driver for the 16 pin MCP23017 I/O expander.
+// The interrupt outputs INTA and INTB are mirrored,
+// so either can be connected to the interrupt GPIO.
+func NewMCP23017(dev I2CTxer) (*GPIOExpander, error) {
+	// IODIR, GPINTEN, INTCON, IOCON, GPPU, GPIO, OLAT of port A with IOCON.BANK = 0
+	chip, err := newMCP23xxx(dev, "MCP23017", 16, [7]uint16{0x00, 0x04, 0x08, 0x0A, 0x0C, 0x12, 0x14})
+	if err != nil {
+		return nil, err
+	}
+	return newGPIOExpander(chip)
+}
+
+// NewMCP23008 returns a driver for the 8 pin MCP23008 I/O expander.
+func NewMCP23008(dev I2CTxer) (*GPIOExpander, error) {
+	chip, err := newMCP23xxx(dev, "MCP23008", 8, [7]uint16{0x00, 0x02, 0x04, 0x05, 0x06, 0x09, 0x0A})
+	if err != nil {
+		return nil, err
+	}
+	return newGPIOExpander(chip)
+}
+
+func (chip *mcp23xxx) name() string {
+	return chip.chipName
+}
+
+func (chip *mcp23xxx) numPins() int {
+	return chip.pins
+}
+
+func (chip *mcp23xxx) init() (inputs, pullUps, outputs uint16, err error) {
+	// Sequential addressing, active low push-pull interrupt output
+	var iocon uint32
+	if chip.pins == 16 {
+		iocon = mcpIOCONMirror
+	}
+	if err = chip.regs.Write(chip.iocon, iocon); err != nil {
+		return 0, 0, 0, err
+	}
+	// Interrupt on change against the previous value
+	if err = chip.regs.Write(chip.intcon, 0); err != nil {
+		return 0, 0, 0, err
+	}
+	if err = chip.regs.Write(chip.gpinten, 0); err != nil {
+		return 0, 0, 0, err
+	}
+	value, err := chip.regs.Read(chip.iodir)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	inputs = uint16(value)
+	value, err = chip.regs.Read(chip.gppu)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	pullUps = uint16(value)
+	value, err = chip.regs.Read(chip.olat)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	outputs = uint16(value)
+	return inputs, pullUps, outputs, nil
+}
+
+func (chip *mcp23xxx) setInputs(inputs uint16) error {
+	return chip.regs.Write(chip.iodir, uint32(inputs))
+}
+
+func (chip *mcp23xxx) setPullUps(pullUps uint16) error {
+	return chip.regs.Write(chip.gppu, uint32(pullUps))
+}
+
+func (chip *mcp23xxx) writeOutputs(outputs uint16) error {
+	return chip.regs.Write(chip.olat, uint32(outputs))
+}
+
+func (chip *mcp23xxx) readPins() (uint16, error) {
+	value, err := chip.regs.Read(chip.gpio)
+	return uint16(value), err
+}
+
+func (chip *mcp23xxx) setInterrupts(enabled uint16) error {
+	return chip.regs.Write(chip.gpinten, uint32(enabled))
+}
+
+// pcf8574 is a quasi-bidirectional I/O expander without registers.
+// Pins written high are weakly pulled up and can be used as inputs,
+// so the byte written is the output value for outputs and 1 for inputs.
+// The interrupt output signals any change of an input.
+type pcf8574 struct {
+	dev     I2CTxer
+	inputs  uint16
+	outputs uint16
+}
+
+// NewPCF8574 returns a driver for the 8 pin PCF8574 or PCF8574A I/O expander.
+// All pins are configured as inputs with the fixed internal pull-ups.
+func NewPCF8574(dev I2CTxer) (*GPIOExpander, error) {
+	return newGPIOExpander(&pcf8574{dev: dev})
+}
+
+func (chip *pcf8574) name() string {
+	return "PCF8574"
+}
+
+func (chip *pcf8574) numPins() int {
+	return 8
+}
+
+func (chip *pcf8574) init() (inputs, pullUps, outputs uint16, err error) {
+	// The state of the chip can't be read back, so start with all inputs
+	chip.inputs = 0xFF
+	err = chip.write()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return 0xFF, 0xFF, 0, nil
+}
+
+func (chip *pcf8574) write() error {
+	return chip.dev.Tx([]byte{byte(chip.inputs | chip.outputs)}, nil)
+}
+
+func (chip *pcf8574) setInputs(inputs uint16) error {
+	chip.inputs = inputs
+	return chip.write()
+}
+
+func (chip *pcf8574) setPullUps(pullUps uint16) error {
+	if pullUps&0xFF != 0xFF {
+		return errors.New("PCF8574 pull-ups can't be disabled")
+	}
+	return nil
+}
+
+func (chip *pcf8574) writeOutputs(outputs uint16) error {
+	chip.outputs = outputs
+	return chip.write()
+}
+
+func (chip *pcf8574) readPins() (uint16, error) {
+	data := make([]byte, 1)
+	err := chip.dev.Tx(nil, data)
+	return uint16(data[0]), err
+}
+
+func (chip *pcf8574) setInterrupts(enabled uint16) error {
+	// The interrupt output can't be configured
+	return nil
+}
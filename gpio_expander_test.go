@@ -0,0 +1,389 @@
+package bbio
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gpioExpanderFakeChip is an 8 pin expander with the
+// outputs connected to the pins.
+type gpioExpanderFakeChip struct {
+	inputs, pullUps, outputs, interrupts uint16
+	setInputsCalls                       int
+}
+
+func (chip *gpioExpanderFakeChip) name() string { return "FAKE" }
+func (chip *gpioExpanderFakeChip) numPins() int { return 8 }
+
+func (chip *gpioExpanderFakeChip) init() (inputs, pullUps, outputs uint16, err error) {
+	return 0xFF, 0, 0, nil
+}
+
+func (chip *gpioExpanderFakeChip) setInputs(inputs uint16) error {
+	chip.inputs = inputs
+	chip.setInputsCalls++
+	return nil
+}
+
+func (chip *gpioExpanderFakeChip) setPullUps(pullUps uint16) error {
+	chip.pullUps = pullUps
+	return nil
+}
+
+func (chip *gpioExpanderFakeChip) writeOutputs(outputs uint16) error {
+	chip.outputs = outputs
+	return nil
+}
+
+func (chip *gpioExpanderFakeChip) readPins() (uint16, error) {
+	return chip.outputs, nil
+}
+
+func (chip *gpioExpanderFakeChip) setInterrupts(enabled uint16) error {
+	chip.interrupts = enabled
+	return nil
+}
+
+func TestGPIOExpanderPins(t *testing.T) {
+	chip := &gpioExpanderFakeChip{}
+	expander, err := newGPIOExpander(chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = expander.Pin(8); err == nil {
+		t.Error("expected error for pin 8")
+	}
+	pin, _ := expander.Pin(3)
+	if direction, _ := pin.Direction(); direction != GPIO_INPUT {
+		t.Errorf("initial direction %s", direction)
+	}
+	if err = pin.SetDirection(GPIO_OUTPUT); err != nil || chip.inputs != 0xF7 {
+		t.Errorf("SetDirection set inputs 0x%02X, %v", chip.inputs, err)
+	}
+	if err = pin.SetValue(true); err != nil || chip.outputs != 0x08 {
+		t.Errorf("SetValue set outputs 0x%02X, %v", chip.outputs, err)
+	}
+	if value, err := pin.Value(); !value || err != nil {
+		t.Errorf("Value returned %t, %v", value, err)
+	}
+	if err = pin.SetPullUpDown(GPIO_PUD_UP); err != nil || chip.pullUps != 0x08 {
+		t.Errorf("SetPullUpDown set pull-ups 0x%02X, %v", chip.pullUps, err)
+	}
+	if pin.SetPullUpDown(GPIO_PUD_DOWN) == nil {
+		t.Error("expected error for pull-down")
+	}
+}
+
+func TestGPIOExpanderEdgeDetectWithoutInterrupt(t *testing.T) {
+	chip := &gpioExpanderFakeChip{}
+	expander, _ := newGPIOExpander(chip)
+	pin, _ := expander.Pin(0)
+	pin.SetDirection(GPIO_OUTPUT)
+	calls := chip.setInputsCalls
+
+	if _, err := pin.AddEdgeDetect(GPIO_RISING_EDGE); err == nil {
+		t.Fatal("expected error without interrupt GPIO")
+	}
+	if chip.setInputsCalls != calls {
+		t.Error("failed AddEdgeDetect changed the pin direction")
+	}
+	if direction, _ := pin.Direction(); direction != GPIO_OUTPUT {
+		t.Errorf("direction %s after failed AddEdgeDetect", direction)
+	}
+	if chip.interrupts != 0 {
+		t.Errorf("interrupts 0x%02X enabled", chip.interrupts)
+	}
+}
+
+// mcp23xxxFakeDevice simulates the registers of a MCP23017 or MCP23008
+// with IOCON.BANK = 0 and sequential addressing.
+// The pins read the outputs or the external levels of the inputs.
+type mcp23xxxFakeDevice struct {
+	mutex    sync.Mutex
+	regs     []byte
+	gpio     int // address of the GPIO register
+	olat     int // address of the OLAT register
+	external uint16
+}
+
+func newMCP23xxxFakeDevice(pins int) *mcp23xxxFakeDevice {
+	dev := &mcp23xxxFakeDevice{regs: make([]byte, 11), gpio: 0x09, olat: 0x0A}
+	if pins == 16 {
+		dev.regs, dev.gpio, dev.olat = make([]byte, 22), 0x12, 0x14
+	}
+	// IODIR resets to all inputs
+	dev.regs[0] = 0xFF
+	if pins == 16 {
+		dev.regs[1] = 0xFF
+	}
+	return dev
+}
+
+// reg16 returns the register pair at address for the MCP23017.
+func (dev *mcp23xxxFakeDevice) reg16(address int) uint16 {
+	dev.mutex.Lock()
+	defer dev.mutex.Unlock()
+	return uint16(dev.regs[address]) | uint16(dev.regs[address+1])<<8
+}
+
+func (dev *mcp23xxxFakeDevice) setExternal(external uint16) {
+	dev.mutex.Lock()
+	dev.external = external
+	dev.mutex.Unlock()
+}
+
+func (dev *mcp23xxxFakeDevice) Tx(w, r []byte) error {
+	dev.mutex.Lock()
+	defer dev.mutex.Unlock()
+	pointer := int(w[0])
+	for _, b := range w[1:] {
+		dev.regs[pointer%len(dev.regs)] = b
+		pointer++
+	}
+	for i := range r {
+		address := pointer % len(dev.regs)
+		r[i] = dev.regs[address]
+		if port := address - dev.gpio; port == 0 || port == 1 && dev.olat > dev.gpio+1 {
+			// Input pins read the external level, outputs the latch
+			iodir := dev.regs[port]
+			olat := dev.regs[dev.olat+port]
+			r[i] = olat&^iodir | byte(dev.external>>(8*uint(port)))&iodir
+		}
+		pointer++
+	}
+	return nil
+}
+
+func TestMCP23017Registers(t *testing.T) {
+	dev := newMCP23xxxFakeDevice(16)
+	dev.regs[0x0C] = 0x01 // GPPUA
+	dev.regs[0x15] = 0x80 // OLATB
+	dev.regs[0x04] = 0xFF // GPINTENA
+	expander, err := NewMCP23017(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expander.Name() != "MCP23017" || expander.NumPins() != 16 {
+		t.Errorf("%s with %d pins", expander.Name(), expander.NumPins())
+	}
+	// IOCON.MIRROR, interrupts on change and disabled
+	if dev.regs[0x0A] != mcpIOCONMirror || dev.reg16(0x08) != 0 || dev.reg16(0x04) != 0 {
+		t.Errorf("IOCON 0x%02X, INTCON 0x%04X, GPINTEN 0x%04X", dev.regs[0x0A], dev.reg16(0x08), dev.reg16(0x04))
+	}
+	if expander.pullUps != 0x0001 || expander.outputs != 0x8000 || expander.inputs != 0xFFFF {
+		t.Errorf("read configuration pull-ups 0x%04X, outputs 0x%04X, inputs 0x%04X",
+			expander.pullUps, expander.outputs, expander.inputs)
+	}
+
+	// Pin 9 is GPB1
+	pin, _ := expander.Pin(9)
+	if err = pin.SetDirection(GPIO_OUTPUT); err != nil || dev.regs[0x00] != 0xFF || dev.regs[0x01] != 0xFD {
+		t.Errorf("SetDirection set IODIR 0x%04X, %v", dev.reg16(0x00), err)
+	}
+	if err = pin.SetValue(true); err != nil || dev.regs[0x14] != 0x00 || dev.regs[0x15] != 0x82 {
+		t.Errorf("SetValue set OLAT 0x%04X, %v", dev.reg16(0x14), err)
+	}
+	pin2, _ := expander.Pin(2)
+	if err = pin2.SetPullUpDown(GPIO_PUD_UP); err != nil || dev.reg16(0x0C) != 0x0005 {
+		t.Errorf("SetPullUpDown set GPPU 0x%04X, %v", dev.reg16(0x0C), err)
+	}
+
+	// GPA2 and GPB7 high at the inputs, GPB1 high as output
+	dev.external = 0x8004
+	if values, err := expander.ReadAll(); values != 0x8204 || err != nil {
+		t.Errorf("ReadAll returned 0x%04X, %v", values, err)
+	}
+	if value, err := pin2.Value(); !value || err != nil {
+		t.Errorf("Value of GPA2 returned %t, %v", value, err)
+	}
+}
+
+func TestMCP23008Registers(t *testing.T) {
+	dev := newMCP23xxxFakeDevice(8)
+	dev.regs[0x06] = 0x10 // GPPU
+	expander, err := NewMCP23008(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expander.Name() != "MCP23008" || expander.NumPins() != 8 {
+		t.Errorf("%s with %d pins", expander.Name(), expander.NumPins())
+	}
+	if dev.regs[0x05] != 0 || expander.pullUps != 0x10 || expander.inputs != 0xFF {
+		t.Errorf("IOCON 0x%02X, pull-ups 0x%02X, inputs 0x%02X", dev.regs[0x05], expander.pullUps, expander.inputs)
+	}
+	if _, err = expander.Pin(8); err == nil {
+		t.Error("expected error for pin 8")
+	}
+
+	pin, _ := expander.Pin(6)
+	if err = pin.SetDirection(GPIO_OUTPUT); err != nil || dev.regs[0x00] != 0xBF {
+		t.Errorf("SetDirection set IODIR 0x%02X, %v", dev.regs[0x00], err)
+	}
+	if err = pin.SetValue(true); err != nil || dev.regs[0x0A] != 0x40 {
+		t.Errorf("SetValue set OLAT 0x%02X, %v", dev.regs[0x0A], err)
+	}
+	if err = pin.SetPullUpDown(GPIO_PUD_UP); err != nil || dev.regs[0x06] != 0x50 {
+		t.Errorf("SetPullUpDown set GPPU 0x%02X, %v", dev.regs[0x06], err)
+	}
+	dev.external = 0x01
+	if values, err := expander.ReadAll(); values != 0x41 || err != nil {
+		t.Errorf("ReadAll returned 0x%02X, %v", values, err)
+	}
+}
+
+// pcf8574FakeDevice simulates the quasi-bidirectional pins of a PCF8574,
+// a pin reads low if it is written low or pulled low externally.
+type pcf8574FakeDevice struct {
+	written  []byte
+	external byte
+}
+
+func (dev *pcf8574FakeDevice) Tx(w, r []byte) error {
+	dev.written = append(dev.written, w...)
+	for i := range r {
+		r[i] = dev.external
+		if len(dev.written) > 0 {
+			r[i] &= dev.written[len(dev.written)-1]
+		}
+	}
+	return nil
+}
+
+func TestPCF8574Port(t *testing.T) {
+	dev := &pcf8574FakeDevice{external: 0xFF}
+	expander, err := NewPCF8574(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dev.written, []byte{0xFF}) {
+		t.Errorf("init wrote % X", dev.written)
+	}
+
+	pin, _ := expander.Pin(3)
+	if err = pin.SetDirection(GPIO_OUTPUT); err != nil {
+		t.Fatal(err)
+	}
+	if err = pin.SetValue(false); err != nil {
+		t.Fatal(err)
+	}
+	if last := dev.written[len(dev.written)-1]; last != 0xF7 {
+		t.Errorf("low output wrote 0x%02X", last)
+	}
+	if err = pin.SetValue(true); err != nil {
+		t.Fatal(err)
+	}
+	if last := dev.written[len(dev.written)-1]; last != 0xFF {
+		t.Errorf("high output wrote 0x%02X", last)
+	}
+	pin.SetValue(false)
+	// An input stays high when its output bit is written
+	if err = pin.SetDirection(GPIO_INPUT); err != nil {
+		t.Fatal(err)
+	}
+	if last := dev.written[len(dev.written)-1]; last != 0xFF {
+		t.Errorf("input wrote 0x%02X", last)
+	}
+
+	dev.external = 0xEF
+	if values, err := expander.ReadAll(); values != 0xEF || err != nil {
+		t.Errorf("ReadAll returned 0x%02X, %v", values, err)
+	}
+	if pin.SetPullUpDown(GPIO_PUD_OFF) == nil {
+		t.Error("expected error for disabling the pull-up")
+	}
+}
+
+func TestGPIOExpanderHandleInterrupts(t *testing.T) {
+	dev := newMCP23xxxFakeDevice(16)
+	expander, err := NewMCP23017(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Edge detection without a GPIO, the edges are sent by the test
+	expander.interrupt = &GPIO{}
+	edges := make(chan bool)
+	expander.stop = make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		expander.handleInterrupts(edges, expander.stop)
+		close(done)
+	}()
+
+	pins := make([]chan bool, 4)
+	for i, edge := range []GPIOEdge{GPIO_RISING_EDGE, GPIO_FALLING_EDGE, GPIO_BOTH_EDGE} {
+		// Pins 0, 8 and 9 to cover both ports
+		pin, _ := expander.Pin([]int{0, 8, 9}[i])
+		pins[i], err = pin.AddEdgeDetect(edge)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dev.reg16(0x04) != 0x0301 {
+		t.Errorf("GPINTEN is 0x%04X instead of 0x0301", dev.reg16(0x04))
+	}
+
+	// interrupt changes the external levels and waits until
+	// handleInterrupts has processed the edge
+	interrupt := func(external uint16) {
+		dev.setExternal(external)
+		edges <- false
+		edges <- false
+	}
+	expect := func(name string, events chan bool, values ...bool) {
+		t.Helper()
+		for _, value := range values {
+			select {
+			case v := <-events:
+				if v != value {
+					t.Errorf("%s: event %t instead of %t", name, v, value)
+				}
+			default:
+				t.Errorf("%s: no event %t", name, value)
+			}
+		}
+		select {
+		case v := <-events:
+			t.Errorf("%s: unexpected event %t", name, v)
+		default:
+		}
+	}
+
+	// Pin 1 changes without edge detection
+	interrupt(0x0303)
+	expect("rising pin 0", pins[0], true)
+	expect("falling pin 8", pins[1])
+	expect("both pin 9", pins[2], true)
+
+	interrupt(0x0002)
+	expect("rising pin 0", pins[0])
+	expect("falling pin 8", pins[1], false)
+	expect("both pin 9", pins[2], false)
+
+	// No change, no events
+	interrupt(0x0002)
+	expect("rising pin 0", pins[0])
+	expect("falling pin 8", pins[1])
+	expect("both pin 9", pins[2])
+
+	// Events are dropped if nobody reads the channel
+	for i := 0; i < 40; i++ {
+		interrupt(uint16(i%2) << 9)
+	}
+	if len(pins[2]) != cap(pins[2]) {
+		t.Errorf("%d of %d buffered events", len(pins[2]), cap(pins[2]))
+	}
+
+	if err = expander.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleInterrupts didn't return after Close")
+	}
+	if dev.reg16(0x04) != 0 {
+		t.Errorf("GPINTEN is 0x%04X after Close", dev.reg16(0x04))
+	}
+}
@@ -0,0 +1,430 @@
+package bbio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Some common PMTK commands for MediaTek based GPS modules, see GPS.SendPMTK
+const (
+	PMTK_SET_NMEA_UPDATE_1HZ     = "220,1000"
+	PMTK_SET_NMEA_UPDATE_5HZ     = "220,200"
+	PMTK_SET_NMEA_UPDATE_10HZ    = "220,100"
+	PMTK_SET_NMEA_OUTPUT_RMCONLY = "314,0,1,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0"
+	PMTK_SET_NMEA_OUTPUT_RMCGGA  = "314,0,1,0,1,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0"
+	PMTK_SET_NMEA_OUTPUT_ALLDATA = "314,1,1,1,1,1,1,0,0,0,0,0,0,0,0,0,0,0,0,0"
+	PMTK_SET_BAUD_9600           = "251,9600"
+	PMTK_SET_BAUD_57600          = "251,57600"
+	PMTK_SET_BAUD_115200         = "251,115200"
+	PMTK_HOT_START               = "101"
+	PMTK_COLD_START              = "103"
+)
+
+var ErrNMEAChecksum = errors.New("NMEA checksum error")
+
+// NMEASentence is a checksum validated NMEA 0183 sentence.
+type NMEASentence struct {
+	// Talker is the talker ID like "GP" or "GN",
+	// or "P" for proprietary sentences.
+	Talker string
+	// Type is the sentence type like "GGA" or for
+	// proprietary sentences the rest of the address like "MTK001".
+	Type   string
+	Fields []string
+}
+
+func nmeaChecksum(body string) byte {
+	var checksum byte
+	for i := 0; i < len(body); i++ {
+		checksum ^= body[i]
+	}
+	return checksum
+}
+
+// ParseNMEASentence parses line and validates its checksum.
+// Leading garbage before the '$' and trailing whitespace are ignored.
+func ParseNMEASentence(line string) (*NMEASentence, error) {
+	start := strings.LastIndexByte(line, '$')
+	if start == -1 {
+		return nil, fmt.Errorf("No NMEA sentence: '%s'", line)
+	}
+	line = strings.TrimSpace(line[start+1:])
+	star := strings.LastIndexByte(line, '*')
+	if star == -1 || star+3 != len(line) {
+		return nil, fmt.Errorf("NMEA sentence without checksum: '%s'", line)
+	}
+	checksum, err := strconv.ParseUint(line[star+1:], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid NMEA checksum: '%s'", line)
+	}
+	body := line[:star]
+	if nmeaChecksum(body) != byte(checksum) {
+		return nil, ErrNMEAChecksum
+	}
+
+	fields := strings.Split(body, ",")
+	address := fields[0]
+	sentence := &NMEASentence{Fields: fields[1:]}
+	switch {
+	case strings.HasPrefix(address, "P"):
+		sentence.Talker, sentence.Type = "P", address[1:]
+	case len(address) == 5:
+		sentence.Talker, sentence.Type = address[:2], address[2:]
+	default:
+		return nil, fmt.Errorf("Invalid NMEA address '%s'", address)
+	}
+	return sentence, nil
+}
+
+func (s *NMEASentence) field(i int) string {
+	if i < len(s.Fields) {
+		return s.Fields[i]
+	}
+	return ""
+}
+
+func (s *NMEASentence) float(i int) float64 {
+	f, _ := strconv.ParseFloat(s.field(i), 64)
+	return f
+}
+
+func (s *NMEASentence) int(i int) int {
+	n, _ := strconv.Atoi(s.field(i))
+	return n
+}
+
+// latLon parses a ddmm.mmmm or dddmm.mmmm value at field i
+// with the hemisphere at field i+1.
+func (s *NMEASentence) latLon(i int) float64 {
+	value := s.float(i)
+	degrees := float64(int(value / 100))
+	result := degrees + (value-degrees*100)/60
+	if hemisphere := s.field(i + 1); hemisphere == "S" || hemisphere == "W" {
+		result = -result
+	}
+	return result
+}
+
+// timeOfDay parses hhmmss.ss at field i.
+func (s *NMEASentence) timeOfDay(i int) (time.Duration, bool) {
+	field := s.field(i)
+	if len(field) < 6 {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(field[0:2])
+	m, err2 := strconv.Atoi(field[2:4])
+	sec, err3 := strconv.ParseFloat(field[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)), true
+}
+
+// date parses ddmmyy at field i.
+func (s *NMEASentence) date(i int) (time.Time, bool) {
+	date, err := time.Parse("020106", s.field(i))
+	return date, err == nil
+}
+
+// GPSSatellite is a satellite in view from a GSV sentence.
+type GPSSatellite struct {
+	PRN       int
+	Elevation int // degrees
+	Azimuth   int // degrees
+	SNR       int // dB, zero if not tracked
+}
+
+// GPSFix holds the navigation data of all received sentences.
+type GPSFix struct {
+	// Time is the UTC time of the last position, zero until
+	// a RMC sentence delivered the date.
+	Time time.Time
+	// Valid is true if the last RMC sentence had status A
+	// or the last GGA sentence had a fix quality above zero.
+	Valid      bool
+	Latitude   float64 // degrees, negative for south
+	Longitude  float64 // degrees, negative for west
+	Altitude   float64 // meters above mean sea level
+	Quality    int     // GGA fix quality: 0 invalid, 1 GPS, 2 DGPS, ...
+	Mode       int     // GSA fix mode: 1 no fix, 2 2D, 3 3D
+	Satellites int     // number of satellites used
+	PDOP       float64
+	HDOP       float64
+	VDOP       float64
+	Speed      float64 // meters per second
+	Course     float64 // degrees true
+	InView     []GPSSatellite
+}
+
+const knotsToMetersPerSecond = 1852.0 / 3600.0
+
+// GPS reads NMEA 0183 sentences from a GPS module
+// connected to an UART or any other io.ReadWriter.
+type GPS struct {
+	w       io.Writer
+	r       *bufio.Reader
+	partial string // line read before a read error like a timeout
+
+	mutex    sync.Mutex
+	fix      GPSFix
+	date     time.Time
+	inView   map[string][]GPSSatellite // complete GSV sequences per talker
+	gsvParts map[string][]GPSSatellite // GSV sequences being received
+
+	fixesOnce sync.Once
+	fixes     chan GPSFix
+	err       error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewGPS(rw io.ReadWriter) *GPS {
+	return &GPS{
+		w:        rw,
+		r:        bufio.NewReader(rw),
+		inView:   make(map[string][]GPSSatellite),
+		gsvParts: make(map[string][]GPSSatellite),
+		done:     make(chan struct{}),
+	}
+}
+
+// ReadSentence reads the next valid sentence
+// and updates the fix with it.
+// Lines with checksum errors and binary data are skipped.
+// After a read timeout ReadSentence can be called again,
+// a partially received line is continued.
+func (gps *GPS) ReadSentence() (*NMEASentence, error) {
+	for {
+		line, err := gps.r.ReadString('\n')
+		if err != nil {
+			gps.partial += line
+			return nil, err
+		}
+		line = gps.partial + line
+		gps.partial = ""
+		sentence, err := ParseNMEASentence(line)
+		if err != nil {
+			continue
+		}
+		gps.update(sentence)
+		return sentence, nil
+	}
+}
+
+// Fix returns the navigation data of all sentences read so far.
+func (gps *GPS) Fix() GPSFix {
+	gps.mutex.Lock()
+	defer gps.mutex.Unlock()
+	return gps.fixLocked()
+}
+
+func (gps *GPS) fixLocked() GPSFix {
+	fix := gps.fix
+	talkers := make([]string, 0, len(gps.inView))
+	for talker := range gps.inView {
+		talkers = append(talkers, talker)
+	}
+	sort.Strings(talkers)
+	fix.InView = nil
+	for _, talker := range talkers {
+		fix.InView = append(fix.InView, gps.inView[talker]...)
+	}
+	return fix
+}
+
+// Fixes starts reading sentences in a goroutine and returns a channel
+// that receives the fix after every GGA and RMC sentence.
+// If the channel is full, the oldest fix is dropped,
+// so reading never blocks and the channel holds the most recent fixes.
+// Read timeouts are ignored, the channel will be closed
+// when reading fails otherwise, see Err, or after Close.
+func (gps *GPS) Fixes() chan GPSFix {
+	gps.fixesOnce.Do(func() {
+		gps.fixes = make(chan GPSFix, 4)
+		go func() {
+			defer close(gps.fixes)
+			for {
+				select {
+				case <-gps.done:
+					return
+				default:
+				}
+				sentence, err := gps.ReadSentence()
+				if IsTimeout(err) {
+					continue
+				}
+				if err != nil {
+					select {
+					case <-gps.done:
+						// Read error caused by closing the reader after Close
+					default:
+						gps.mutex.Lock()
+						gps.err = err
+						gps.mutex.Unlock()
+					}
+					return
+				}
+				if sentence.Type == "GGA" || sentence.Type == "RMC" {
+					gps.sendFix(gps.Fix())
+				}
+			}
+		}()
+	})
+	return gps.fixes
+}
+
+// sendFix sends fix to the channel of Fixes,
+// dropping the oldest fix if the channel is full.
+func (gps *GPS) sendFix(fix GPSFix) {
+	for {
+		select {
+		case gps.fixes <- fix:
+			return
+		default:
+		}
+		select {
+		case <-gps.fixes:
+		default:
+		}
+	}
+}
+
+// Close stops the goroutine started by Fixes, which closes the channel.
+// The io.ReadWriter of the GPS is not closed, a blocking read
+// returns after its read timeout or when the caller closes the UART.
+func (gps *GPS) Close() error {
+	gps.closeOnce.Do(func() { close(gps.done) })
+	return nil
+}
+
+// Err returns the error that caused the channel
+// returned by Fixes to be closed.
+func (gps *GPS) Err() error {
+	gps.mutex.Lock()
+	defer gps.mutex.Unlock()
+	return gps.err
+}
+
+func (gps *GPS) update(s *NMEASentence) {
+	gps.mutex.Lock()
+	defer gps.mutex.Unlock()
+
+	fix := &gps.fix
+	switch s.Type {
+	case "GGA":
+		// time, lat, N/S, lon, E/W, quality, satellites, HDOP, altitude, M, ...
+		fix.Quality = s.int(5)
+		fix.Valid = fix.Quality > 0
+		if !fix.Valid {
+			return
+		}
+		if tod, ok := s.timeOfDay(0); ok && !gps.date.IsZero() {
+			fix.Time = gps.date.Add(tod)
+		}
+		fix.Latitude = s.latLon(1)
+		fix.Longitude = s.latLon(3)
+		fix.Satellites = s.int(6)
+		fix.HDOP = s.float(7)
+		fix.Altitude = s.float(8)
+
+	case "RMC":
+		// time, status, lat, N/S, lon, E/W, speed knots, course, date, ...
+		fix.Valid = s.field(1) == "A"
+		if date, ok := s.date(8); ok {
+			gps.date = date
+			if tod, ok := s.timeOfDay(0); ok {
+				fix.Time = date.Add(tod)
+			}
+		}
+		if !fix.Valid {
+			return
+		}
+		fix.Latitude = s.latLon(2)
+		fix.Longitude = s.latLon(4)
+		// Speed and course are empty without movement on some modules
+		if s.field(6) != "" {
+			fix.Speed = s.float(6) * knotsToMetersPerSecond
+		}
+		if s.field(7) != "" {
+			fix.Course = s.float(7)
+		}
+
+	case "GSA":
+		// mode A/M, fix mode, 12 satellite PRNs, PDOP, HDOP, VDOP
+		fix.Mode = s.int(1)
+		fix.PDOP = s.float(14)
+		fix.HDOP = s.float(15)
+		fix.VDOP = s.float(16)
+
+	case "GSV":
+		// number of sentences, sentence number, satellites in view,
+		// then up to 4 times PRN, elevation, azimuth, SNR
+		total, number := s.int(0), s.int(1)
+		if number == 1 {
+			gps.gsvParts[s.Talker] = nil
+		}
+		satellites := gps.gsvParts[s.Talker]
+		for i := 3; i+2 < len(s.Fields) && s.field(i) != ""; i += 4 {
+			satellites = append(satellites, GPSSatellite{
+				PRN:       s.int(i),
+				Elevation: s.int(i + 1),
+				Azimuth:   s.int(i + 2),
+				SNR:       s.int(i + 3),
+			})
+		}
+		gps.gsvParts[s.Talker] = satellites
+		if number == total {
+			gps.inView[s.Talker] = satellites
+			delete(gps.gsvParts, s.Talker)
+		}
+
+	case "VTG":
+		// course true, T, course magnetic, M, speed knots, N, speed km/h, K
+		if s.field(0) != "" {
+			fix.Course = s.float(0)
+		}
+		if s.field(6) != "" {
+			fix.Speed = s.float(6) / 3.6
+		}
+	}
+}
+
+// SendNMEA sends body, for example "PMTK220,1000",
+// as NMEA sentence with '$', checksum and line end.
+func (gps *GPS) SendNMEA(body string) error {
+	_, err := fmt.Fprintf(gps.w, "$%s*%02X\r\n", body, nmeaChecksum(body))
+	return err
+}
+
+// SendPMTK sends a command to a MediaTek based GPS module,
+// for example PMTK_SET_NMEA_UPDATE_5HZ.
+func (gps *GPS) SendPMTK(command string) error {
+	return gps.SendNMEA("PMTK" + command)
+}
+
+// SendUBX sends a binary UBX protocol message
+// to a u-blox GPS module.
+func (gps *GPS) SendUBX(class, id byte, payload []byte) error {
+	if len(payload) > 0xFFFF {
+		return fmt.Errorf("UBX payload too long: %d bytes", len(payload))
+	}
+	msg := make([]byte, 0, 8+len(payload))
+	msg = append(msg, 0xB5, 0x62, class, id, byte(len(payload)), byte(len(payload)>>8))
+	msg = append(msg, payload...)
+	// 8 bit Fletcher checksum over class, id, length and payload
+	var ckA, ckB byte
+	for _, b := range msg[2:] {
+		ckA += b
+		ckB += ckA
+	}
+	msg = append(msg, ckA, ckB)
+	_, err := gps.w.Write(msg)
+	return err
+}
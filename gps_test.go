@@ -0,0 +1,164 @@
+package bbio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func nmeaLine(body string) string {
+	return fmt.Sprintf("$%s*%02X\r\n", body, nmeaChecksum(body))
+}
+
+func TestParseNMEASentence(t *testing.T) {
+	sentence, err := ParseNMEASentence("\x00\xB5garbage$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sentence.Talker != "GP" || sentence.Type != "GGA" || len(sentence.Fields) != 14 || sentence.Fields[0] != "123519" {
+		t.Errorf("parsed %+v", sentence)
+	}
+
+	sentence, err = ParseNMEASentence(nmeaLine("PMTK001,220,3"))
+	if err != nil || sentence.Talker != "P" || sentence.Type != "MTK001" {
+		t.Errorf("parsed %+v, %v", sentence, err)
+	}
+
+	_, err = ParseNMEASentence("$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48")
+	if err != ErrNMEAChecksum {
+		t.Errorf("wrong checksum returned %v", err)
+	}
+	for _, line := range []string{"", "GPGGA,1*00", "$GPGGA,1", "$GPGGA,1*4", "$GPGGA,1*XY", nmeaLine("GPGGAX,1")} {
+		if _, err := ParseNMEASentence(line); err == nil {
+			t.Errorf("no error for %q", line)
+		}
+	}
+}
+
+func TestGPSFix(t *testing.T) {
+	input := strings.Join([]string{
+		"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A\r\n",
+		"$GPGGA,123520,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*4D\r\n",
+		nmeaLine("GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1"),
+		nmeaLine("GPGSV,2,1,05,01,40,083,46,02,17,308,41,12,07,344,39,14,22,228,45"),
+		nmeaLine("GPGSV,2,2,05,30,10,040,"),
+		// Empty course and speed fields keep the previous values
+		nmeaLine("GPVTG,,T,,M,,N,,K"),
+		nmeaLine("GPRMC,123521,A,4807.038,S,01131.000,W,,,230394,003.1,W"),
+	}, "")
+	gps := NewGPS(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(input), io.Discard})
+	for {
+		_, err := gps.ReadSentence()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fix := gps.Fix()
+	expectedTime := time.Date(1994, 3, 23, 12, 35, 21, 0, time.UTC)
+	if !fix.Valid || !fix.Time.Equal(expectedTime) {
+		t.Errorf("fix valid %t at %s", fix.Valid, fix.Time)
+	}
+	latitude := -(48 + 7.038/60)
+	longitude := -(11 + 31.0/60)
+	if math.Abs(fix.Latitude-latitude) > 1e-9 || math.Abs(fix.Longitude-longitude) > 1e-9 {
+		t.Errorf("position %f, %f instead of %f, %f", fix.Latitude, fix.Longitude, latitude, longitude)
+	}
+	if math.Abs(fix.Speed-22.4*knotsToMetersPerSecond) > 1e-9 || fix.Course != 84.4 {
+		t.Errorf("speed %f, course %f", fix.Speed, fix.Course)
+	}
+	if fix.Quality != 1 || fix.Satellites != 8 || fix.Altitude != 545.4 {
+		t.Errorf("GGA quality %d, satellites %d, altitude %f", fix.Quality, fix.Satellites, fix.Altitude)
+	}
+	if fix.Mode != 3 || fix.PDOP != 2.5 || fix.HDOP != 1.3 || fix.VDOP != 2.1 {
+		t.Errorf("GSA mode %d, DOP %f/%f/%f", fix.Mode, fix.PDOP, fix.HDOP, fix.VDOP)
+	}
+	if len(fix.InView) != 5 || fix.InView[4] != (GPSSatellite{PRN: 30, Elevation: 10, Azimuth: 40}) {
+		t.Errorf("satellites in view %+v", fix.InView)
+	}
+}
+
+func TestGPSReadAfterTimeout(t *testing.T) {
+	line := nmeaLine("GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W")
+	// A timeout in the middle of each line
+	gps := NewGPS(newChunkReader([]byte(line[:10]), []byte(line[10:]+line[:30]), []byte(line[30:])))
+	count := 0
+	for fix := range gps.Fixes() {
+		if !fix.Valid || fix.Course != 84.4 {
+			t.Errorf("fix %+v", fix)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Errorf("%d fixes instead of 2", count)
+	}
+	if gps.Err() != io.EOF {
+		t.Errorf("Err returned %v instead of io.EOF", gps.Err())
+	}
+}
+
+func TestGPSFixesDropsOldest(t *testing.T) {
+	var input string
+	for second := 0; second < 10; second++ {
+		input += nmeaLine(fmt.Sprintf("GPRMC,1235%02d,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W", second))
+	}
+	gps := NewGPS(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(input), io.Discard})
+	fixes := gps.Fixes()
+	// Reading doesn't block although nobody receives the fixes
+	for gps.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	second := 6
+	for fix := range fixes {
+		expected := time.Date(1994, 3, 23, 12, 35, second, 0, time.UTC)
+		if !fix.Time.Equal(expected) {
+			t.Errorf("fix at %s instead of %s", fix.Time, expected)
+		}
+		second++
+	}
+	if second != 10 {
+		t.Errorf("received fixes until second %d", second)
+	}
+}
+
+// gpsTimeoutReader returns a read timeout after a millisecond.
+type gpsTimeoutReader struct{}
+
+func (gpsTimeoutReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return 0, os.ErrDeadlineExceeded
+}
+
+func TestGPSClose(t *testing.T) {
+	gps := NewGPS(struct {
+		io.Reader
+		io.Writer
+	}{gpsTimeoutReader{}, io.Discard})
+	fixes := gps.Fixes()
+	gps.Close()
+	select {
+	case _, ok := <-fixes:
+		if ok {
+			t.Error("received a fix")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fixes channel not closed after Close")
+	}
+	if gps.Err() != nil {
+		t.Errorf("Err returned %v after Close", gps.Err())
+	}
+	gps.Close()
+}
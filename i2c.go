@@ -6,6 +6,7 @@ package bbio
 import "C"
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
@@ -25,6 +26,48 @@ func (errI2C ErrI2C) Error() string {
 	return fmt.Sprintf("I2C.%s error: %s", errI2C.function, errI2C.cause)
 }
 
+// Unwrap returns the cause, usually a syscall.Errno.
+func (errI2C ErrI2C) Unwrap() error {
+	return errI2C.cause
+}
+
+// Is makes errors.Is match ErrI2CNAK, ErrI2CArbitrationLost,
+// ErrI2CTimeout and ErrI2CUnsupported for the corresponding
+// error codes of the kernel.
+func (errI2C ErrI2C) Is(target error) bool {
+	var errno syscall.Errno
+	if !errors.As(errI2C.cause, &errno) {
+		return false
+	}
+	switch target {
+	case ErrI2CNAK:
+		return errno == syscall.ENXIO || errno == syscall.EREMOTEIO
+	case ErrI2CArbitrationLost:
+		return errno == syscall.EAGAIN
+	case ErrI2CTimeout:
+		return errno == syscall.ETIMEDOUT
+	case ErrI2CUnsupported:
+		return errno == syscall.EOPNOTSUPP || errno == syscall.EPROTONOSUPPORT
+	}
+	return false
+}
+
+// Errors returned by I2C methods wrapped in an ErrI2C,
+// use errors.Is to check for them.
+var (
+	// ErrI2CNAK means that the device did not acknowledge
+	// its address or data, so it is not present or busy.
+	ErrI2CNAK = errors.New("I2C device did not acknowledge")
+	// ErrI2CArbitrationLost means that another master used the bus.
+	ErrI2CArbitrationLost = errors.New("I2C bus arbitration lost")
+	// ErrI2CTimeout means that the transfer did not complete in time,
+	// usually because a device holds the clock line low.
+	ErrI2CTimeout = errors.New("I2C bus timeout")
+	// ErrI2CUnsupported means that the adapter does not
+	// support the operation, see I2C.Functionality.
+	ErrI2CUnsupported = errors.New("I2C operation not supported by adapter")
+)
+
 func wrapErr(function string, err error) error {
 	if err == nil {
 		return nil
@@ -37,42 +80,54 @@ func wrapErr(function string, err error) error {
 }
 
 // I2C is a port of https://github.com/bivab/smbus-cffi/
+// It is not safe for concurrent use, use I2CBus to share a bus.
 type I2C struct {
-	file    *os.File
-	address int
+	file        *os.File
+	address     int
+	tenBit      bool
+	forced      bool
+	retryPolicy *I2CRetryPolicy
+	pec         bool
 }
 
 // Connects the object to the specified SMBus.
 func NewI2C(bus, address int) (*I2C, error) {
-	filename := fmt.Sprintf("/dev/i2c-%d", bus)
-	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	i2c, err := openI2C(bus)
 	if err != nil {
 		return nil, err
 	}
 
-	i2c := &I2C{file: file, address: -1}
 	err = i2c.SetAddress(address)
 	if err != nil {
-		file.Close()
+		i2c.Close()
 		return nil, err
 	}
 
 	return i2c, nil
 }
 
+// openI2C opens /dev/i2c-bus without setting an address.
+func openI2C(bus int) (*I2C, error) {
+	filename := fmt.Sprintf("/dev/i2c-%d", bus)
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &I2C{file: file, address: -1}, nil
+}
+
 func (i2c *I2C) Address() int {
 	return i2c.address
 }
 
+// SetAddress sets the 7 bit address of the device for all following operations.
+// The reserved addresses 0x00-0x07 and 0x78-0x7F are rejected,
+// use ForceAddress for them.
 func (i2c *I2C) SetAddress(address int) error {
-	if address != i2c.address {
-		result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_SLAVE, uintptr(address))
-		if result != 0 {
-			return ErrI2C{"SetAddress", errno}
-		}
-		i2c.address = address
+	if address < I2C_MIN_ADDRESS || address > I2C_MAX_ADDRESS {
+		return ErrI2C{"SetAddress", fmt.Errorf("%w: 0x%02X", ErrI2CReservedAddress, address)}
 	}
-	return nil
+	return wrapErr("SetAddress", i2c.setAddress(address, false, false))
 }
 
 func (i2c *I2C) smbusAccess(readWrite, register uint8, size int, data unsafe.Pointer) (uintptr, error) {
@@ -82,9 +137,17 @@ func (i2c *I2C) smbusAccess(readWrite, register uint8, size int, data unsafe.Poi
 		size:       C.int(size),
 		data:       (*C.union_i2c_smbus_data)(data),
 	}
-	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_SMBUS, uintptr(unsafe.Pointer(&args)))
-	if int(result) == -1 {
-		return 0, errno
+	var result uintptr
+	err := i2c.retry(func() error {
+		var errno syscall.Errno
+		result, _, errno = syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_SMBUS, uintptr(unsafe.Pointer(&args)))
+		if int(result) == -1 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 	return result, nil
 }
@@ -273,16 +336,56 @@ func (i2c *I2C) WriteBlock(register uint8, block []byte) error {
 	return wrapErr("WriteBlock", err)
 }
 
-// TODO: Perform I2C Block Read transaction.
-// With if len == 32 then arg = C.I2C_SMBUS_I2C_BLOCK_BROKEN instead of I2C_SMBUS_I2C_BLOCK_DATA ???
+// ReadI2CBlock reads length bytes from a device, starting at a designated register.
+// Unlike ReadBlock the device does not send a length byte,
+// which is what most devices implement for burst reads.
+// length must be in the range 1 to 32.
+func (i2c *I2C) ReadI2CBlock(register uint8, length int) ([]byte, error) {
+	if length < 1 || length > C.I2C_SMBUS_BLOCK_MAX {
+		return nil, wrapErr("ReadI2CBlock", fmt.Errorf("Length is %d, but must be in the range 1 to %d", length, C.I2C_SMBUS_BLOCK_MAX))
+	}
+	data := make([]byte, C.I2C_SMBUS_BLOCK_MAX+2)
+	data[0] = byte(length)
+	// Like i2c-tools, old kernels only handle the maximum length
+	// correctly with I2C_SMBUS_I2C_BLOCK_BROKEN
+	size := C.I2C_SMBUS_I2C_BLOCK_DATA
+	if length == C.I2C_SMBUS_BLOCK_MAX {
+		size = C.I2C_SMBUS_I2C_BLOCK_BROKEN
+	}
+	_, err := i2c.smbusAccess(C.I2C_SMBUS_READ, register, size, unsafe.Pointer(&data[0]))
+	if err != nil {
+		return nil, wrapErr("ReadI2CBlock", err)
+	}
+	return data[1 : 1+data[0]], nil
+}
+
+// WriteI2CBlock writes 1 to 32 bytes to a device, starting at a designated register.
+// Unlike WriteBlock no length byte is sent.
+func (i2c *I2C) WriteI2CBlock(register uint8, block []byte) error {
+	length := len(block)
+	if length == 0 || length > C.I2C_SMBUS_BLOCK_MAX {
+		return wrapErr("WriteI2CBlock", fmt.Errorf("Length of block is %d, but must be in the range 1 to %d", length, C.I2C_SMBUS_BLOCK_MAX))
+	}
+	data := make([]byte, C.I2C_SMBUS_BLOCK_MAX+2)
+	data[0] = byte(length)
+	copy(data[1:], block)
+	_, err := i2c.smbusAccess(C.I2C_SMBUS_WRITE, register, C.I2C_SMBUS_I2C_BLOCK_BROKEN, unsafe.Pointer(&data[0]))
+	return wrapErr("WriteI2CBlock", err)
+}
 
 func (i2c *I2C) Read(p []byte) (n int, err error) {
-	n, err = i2c.file.Read(p)
+	err = i2c.retry(func() error {
+		n, err = i2c.file.Read(p)
+		return err
+	})
 	return n, wrapErr("Read", err)
 }
 
 func (i2c *I2C) Write(p []byte) (n int, err error) {
-	n, err = i2c.file.Write(p)
+	err = i2c.retry(func() error {
+		n, err = i2c.file.Write(p)
+		return err
+	})
 	return n, wrapErr("Write", err)
 }
 
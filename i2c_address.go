@@ -0,0 +1,82 @@
+package bbio
+
+// #include <linux/i2c-dev.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Valid address ranges. 7 bit addresses outside of
+// I2C_MIN_ADDRESS to I2C_MAX_ADDRESS are reserved by the I2C specification
+// for general call, CBUS, high speed mode and 10 bit addressing.
+const (
+	I2C_MIN_ADDRESS        = 0x08
+	I2C_MAX_ADDRESS        = 0x77
+	I2C_MAX_FORCED_ADDRESS = 0x7F
+	I2C_MAX_TENBIT_ADDRESS = 0x3FF
+)
+
+// ErrI2CReservedAddress is returned for addresses
+// outside of the valid range.
+var ErrI2CReservedAddress = errors.New("I2C address reserved or out of range")
+
+// SetTenBitAddress sets the 10 bit address of the device
+// for all following operations.
+// The adapter must support I2C_FUNC_10BIT_ADDR.
+func (i2c *I2C) SetTenBitAddress(address int) error {
+	if address < 0 || address > I2C_MAX_TENBIT_ADDRESS {
+		return ErrI2C{"SetTenBitAddress", fmt.Errorf("%w: 0x%03X", ErrI2CReservedAddress, address)}
+	}
+	return wrapErr("SetTenBitAddress", i2c.setAddress(address, true, false))
+}
+
+// ForceAddress sets the 7 bit address of the device like SetAddress,
+// but allows reserved addresses and addresses claimed by a kernel driver
+// using the I2C_SLAVE_FORCE ioctl.
+// Accessing a device concurrently with its kernel driver
+// can confuse the driver and the device.
+func (i2c *I2C) ForceAddress(address int) error {
+	if address < 0 || address > I2C_MAX_FORCED_ADDRESS {
+		return ErrI2C{"ForceAddress", fmt.Errorf("%w: 0x%02X", ErrI2CReservedAddress, address)}
+	}
+	return wrapErr("ForceAddress", i2c.setAddress(address, false, true))
+}
+
+// TenBit returns true if the address is a 10 bit address.
+func (i2c *I2C) TenBit() bool {
+	return i2c.tenBit
+}
+
+// setAddress issues the ioctls to change the address mode
+// and the address without validating the address.
+func (i2c *I2C) setAddress(address int, tenBit, force bool) error {
+	if address == i2c.address && tenBit == i2c.tenBit && force == i2c.forced {
+		return nil
+	}
+	if tenBit != i2c.tenBit {
+		var arg uintptr
+		if tenBit {
+			arg = 1
+		}
+		err := i2c.ioctl("SetTenBitAddress", C.I2C_TENBIT, arg)
+		if err != nil {
+			return err
+		}
+		i2c.tenBit = tenBit
+	}
+	request := uintptr(C.I2C_SLAVE)
+	if force {
+		request = C.I2C_SLAVE_FORCE
+	}
+	err := i2c.ioctl("SetAddress", request, uintptr(address))
+	if err != nil {
+		// Force a new ioctl for the next address
+		i2c.address = -1
+		return err
+	}
+	i2c.address = address
+	i2c.forced = force
+	return nil
+}
@@ -0,0 +1,50 @@
+package bbio
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestI2CAddressValidation(t *testing.T) {
+	i2c := &I2C{address: -1}
+	for _, address := range []int{-1, 0x00, 0x07, 0x78, 0x7F} {
+		if err := i2c.SetAddress(address); !errors.Is(err, ErrI2CReservedAddress) {
+			t.Errorf("SetAddress(0x%02X) returned %v", address, err)
+		}
+	}
+	for _, address := range []int{-1, 0x80} {
+		if err := i2c.ForceAddress(address); !errors.Is(err, ErrI2CReservedAddress) {
+			t.Errorf("ForceAddress(0x%02X) returned %v", address, err)
+		}
+	}
+	for _, address := range []int{-1, 0x400} {
+		if err := i2c.SetTenBitAddress(address); !errors.Is(err, ErrI2CReservedAddress) {
+			t.Errorf("SetTenBitAddress(0x%03X) returned %v", address, err)
+		}
+	}
+	if i2c.Address() != -1 || i2c.TenBit() {
+		t.Errorf("address changed to 0x%X, ten bit %t", i2c.Address(), i2c.TenBit())
+	}
+
+	// Setting the current address needs no ioctl
+	i2c = &I2C{address: 0x08}
+	if err := i2c.SetAddress(0x08); err != nil {
+		t.Errorf("SetAddress of current address returned %v", err)
+	}
+}
+
+func TestI2CDeviceInvalidAddress(t *testing.T) {
+	// f must not be called if the address can't be set
+	bus := &I2CBus{i2c: &I2C{address: -1}}
+	devices := []*I2CDevice{bus.Device(0x03), bus.Device(0x78), bus.TenBitDevice(0x400), bus.ForcedDevice(0x80)}
+	for _, dev := range devices {
+		called := false
+		err := dev.Do(func(i2c *I2C) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, ErrI2CReservedAddress) || called {
+			t.Errorf("Do for address 0x%X returned %v, called %t", dev.Address(), err, called)
+		}
+	}
+}
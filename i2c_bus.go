@@ -0,0 +1,176 @@
+package bbio
+
+import "sync"
+
+// I2CBus can be shared by multiple goroutines and drivers
+// talking to different devices on the same bus.
+// Every transaction of an I2CDevice locks the bus
+// and sets the device address.
+type I2CBus struct {
+	nr    int
+	mutex sync.Mutex
+	i2c   *I2C
+}
+
+// NewI2CBus opens /dev/i2c-nr
+func NewI2CBus(nr int) (*I2CBus, error) {
+	i2c, err := openI2C(nr)
+	if err != nil {
+		return nil, err
+	}
+	return &I2CBus{nr: nr, i2c: i2c}, nil
+}
+
+func (bus *I2CBus) Nr() int {
+	return bus.nr
+}
+
+// Device returns a handle for the device at the 7 bit address.
+// No bus transaction happens.
+func (bus *I2CBus) Device(address int) *I2CDevice {
+	return &I2CDevice{bus: bus, address: address}
+}
+
+// TenBitDevice returns a handle for the device at the 10 bit address.
+func (bus *I2CBus) TenBitDevice(address int) *I2CDevice {
+	return &I2CDevice{bus: bus, address: address, tenBit: true}
+}
+
+// ForcedDevice returns a handle for the device at the 7 bit address
+// that is used even if the address is reserved or claimed by
+// a kernel driver, see I2C.ForceAddress.
+func (bus *I2CBus) ForcedDevice(address int) *I2CDevice {
+	return &I2CDevice{bus: bus, address: address, forced: true}
+}
+
+// Transfer executes messages as one combined transaction, see I2C.Transfer.
+func (bus *I2CBus) Transfer(messages []I2CMessage) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.i2c.Transfer(messages)
+}
+
+func (bus *I2CBus) Close() error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.i2c.Close()
+}
+
+// I2CDevice is a device at an address of an I2CBus.
+// All methods are safe for concurrent use.
+type I2CDevice struct {
+	bus     *I2CBus
+	address int
+	tenBit  bool
+	forced  bool
+	pec     bool
+}
+
+func (dev *I2CDevice) Bus() *I2CBus {
+	return dev.bus
+}
+
+func (dev *I2CDevice) Address() int {
+	return dev.address
+}
+
+// Do locks the bus, sets the address and PEC mode of the device
+// and calls f with the I2C of the bus.
+// Use it to combine several operations without another
+// goroutine accessing the bus in between.
+// f must not change the address or PEC mode of the I2C.
+func (dev *I2CDevice) Do(f func(i2c *I2C) error) error {
+	dev.bus.mutex.Lock()
+	defer dev.bus.mutex.Unlock()
+	i2c := dev.bus.i2c
+	var err error
+	switch {
+	case dev.tenBit:
+		err = i2c.SetTenBitAddress(dev.address)
+	case dev.forced:
+		err = i2c.ForceAddress(dev.address)
+	default:
+		err = i2c.SetAddress(dev.address)
+	}
+	if err != nil {
+		return err
+	}
+	if i2c.pec != dev.pec {
+		err = i2c.SetPEC(dev.pec)
+		if err != nil {
+			return err
+		}
+	}
+	return f(i2c)
+}
+
+// Tx writes w and then reads into r with a repeated start in between.
+func (dev *I2CDevice) Tx(w, r []byte) error {
+	return dev.Do(func(i2c *I2C) error {
+		return i2c.Tx(w, r)
+	})
+}
+
+func (dev *I2CDevice) Read(p []byte) (n int, err error) {
+	err = dev.Do(func(i2c *I2C) error {
+		n, err = i2c.Read(p)
+		return err
+	})
+	return n, err
+}
+
+func (dev *I2CDevice) Write(p []byte) (n int, err error) {
+	err = dev.Do(func(i2c *I2C) error {
+		n, err = i2c.Write(p)
+		return err
+	})
+	return n, err
+}
+
+// ReadUint8Reg reads a single byte from a designated register.
+func (dev *I2CDevice) ReadUint8Reg(register uint8) (value uint8, err error) {
+	err = dev.Do(func(i2c *I2C) error {
+		value, err = i2c.ReadUint8Reg(register)
+		return err
+	})
+	return value, err
+}
+
+// WriteUint8Reg writes a single byte to a designated register.
+func (dev *I2CDevice) WriteUint8Reg(register uint8, value uint8) error {
+	return dev.Do(func(i2c *I2C) error {
+		return i2c.WriteUint8Reg(register, value)
+	})
+}
+
+// ReadUint16Reg reads a 16 bit word from a designated register.
+func (dev *I2CDevice) ReadUint16Reg(register uint8) (value uint16, err error) {
+	err = dev.Do(func(i2c *I2C) error {
+		value, err = i2c.ReadUint16Reg(register)
+		return err
+	})
+	return value, err
+}
+
+// WriteUint16Reg writes a 16 bit word to a designated register.
+func (dev *I2CDevice) WriteUint16Reg(register uint8, value uint16) error {
+	return dev.Do(func(i2c *I2C) error {
+		return i2c.WriteUint16Reg(register, value)
+	})
+}
+
+// ReadI2CBlock reads length bytes starting at a designated register.
+func (dev *I2CDevice) ReadI2CBlock(register uint8, length int) (block []byte, err error) {
+	err = dev.Do(func(i2c *I2C) error {
+		block, err = i2c.ReadI2CBlock(register, length)
+		return err
+	})
+	return block, err
+}
+
+// WriteI2CBlock writes 1 to 32 bytes starting at a designated register.
+func (dev *I2CDevice) WriteI2CBlock(register uint8, block []byte) error {
+	return dev.Do(func(i2c *I2C) error {
+		return i2c.WriteI2CBlock(register, block)
+	})
+}
@@ -0,0 +1,54 @@
+package bbio
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestI2CDeviceDoLocksBus(t *testing.T) {
+	// The address is already set, so Do needs no ioctl
+	bus := &I2CBus{i2c: &I2C{address: 0x20}}
+	dev := bus.Device(0x20)
+	if dev.Bus() != bus {
+		t.Error("wrong bus")
+	}
+
+	count := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				dev.Do(func(i2c *I2C) error {
+					if i2c != bus.i2c {
+						t.Error("Do called f with wrong I2C")
+					}
+					count++
+					return nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 400 {
+		t.Errorf("f called %d times instead of 400", count)
+	}
+}
+
+func TestOpenMissingI2CBus(t *testing.T) {
+	if _, err := NewI2CBus(999); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewI2CBus returned %v", err)
+	}
+	if _, err := NewI2C(999, 0x20); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewI2C returned %v", err)
+	}
+	if _, err := ScanI2C(999); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ScanI2C returned %v", err)
+	}
+	if _, err := IdentifyI2C(999, 0x20); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("IdentifyI2C returned %v", err)
+	}
+}
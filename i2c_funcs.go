@@ -0,0 +1,61 @@
+package bbio
+
+// #include <linux/i2c-dev.h>
+import "C"
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// I2CFunctionality is the bitmask of operations
+// supported by an I2C adapter, see I2C.Functionality.
+type I2CFunctionality uint64
+
+// Values of I2CFunctionality, same as I2C_FUNC_* of the kernel
+const (
+	I2C_FUNC_I2C                    I2CFunctionality = 0x00000001 // Plain I2C transfers, see I2C.Transfer
+	I2C_FUNC_10BIT_ADDR             I2CFunctionality = 0x00000002
+	I2C_FUNC_PROTOCOL_MANGLING      I2CFunctionality = 0x00000004 // I2C_MSG_IGNORE_NAK and others
+	I2C_FUNC_SMBUS_PEC              I2CFunctionality = 0x00000008
+	I2C_FUNC_NOSTART                I2CFunctionality = 0x00000010 // I2C_MSG_NO_START
+	I2C_FUNC_SLAVE                  I2CFunctionality = 0x00000020
+	I2C_FUNC_SMBUS_BLOCK_PROC_CALL  I2CFunctionality = 0x00008000
+	I2C_FUNC_SMBUS_QUICK            I2CFunctionality = 0x00010000
+	I2C_FUNC_SMBUS_READ_BYTE        I2CFunctionality = 0x00020000
+	I2C_FUNC_SMBUS_WRITE_BYTE       I2CFunctionality = 0x00040000
+	I2C_FUNC_SMBUS_READ_BYTE_DATA   I2CFunctionality = 0x00080000
+	I2C_FUNC_SMBUS_WRITE_BYTE_DATA  I2CFunctionality = 0x00100000
+	I2C_FUNC_SMBUS_READ_WORD_DATA   I2CFunctionality = 0x00200000
+	I2C_FUNC_SMBUS_WRITE_WORD_DATA  I2CFunctionality = 0x00400000
+	I2C_FUNC_SMBUS_PROC_CALL        I2CFunctionality = 0x00800000
+	I2C_FUNC_SMBUS_READ_BLOCK_DATA  I2CFunctionality = 0x01000000 // Also needed for I2C_MSG_RECV_LEN
+	I2C_FUNC_SMBUS_WRITE_BLOCK_DATA I2CFunctionality = 0x02000000
+	I2C_FUNC_SMBUS_READ_I2C_BLOCK   I2CFunctionality = 0x04000000
+	I2C_FUNC_SMBUS_WRITE_I2C_BLOCK  I2CFunctionality = 0x08000000
+	I2C_FUNC_SMBUS_HOST_NOTIFY      I2CFunctionality = 0x10000000
+)
+
+// Has returns true if all bits of funcs are set.
+func (f I2CFunctionality) Has(funcs I2CFunctionality) bool {
+	return f&funcs == funcs
+}
+
+// Functionality returns the operations supported by the adapter
+// using the I2C_FUNCS ioctl.
+// Operations that are not supported fail with ErrI2CUnsupported.
+func (i2c *I2C) Functionality() (I2CFunctionality, error) {
+	var funcs C.ulong
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_FUNCS, uintptr(unsafe.Pointer(&funcs)))
+	if int(result) == -1 {
+		return 0, ErrI2C{"Functionality", errno}
+	}
+	return I2CFunctionality(funcs), nil
+}
+
+// Functionality returns the operations supported by the adapter.
+func (bus *I2CBus) Functionality() (I2CFunctionality, error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.i2c.Functionality()
+}
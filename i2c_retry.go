@@ -0,0 +1,183 @@
+package bbio
+
+// #include <linux/i2c-dev.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// I2CRetryPolicy repeats I2C operations that failed because
+// the device did not acknowledge (EREMOTEIO) or the bus timed out (ETIMEDOUT),
+// which happens with long cables and electrical noise.
+type I2CRetryPolicy struct {
+	// Retries is the number of repetitions after the first try
+	Retries int
+	// Backoff is the delay before the first retry,
+	// it is doubled for every further retry
+	Backoff time.Duration
+}
+
+// IsTransientI2CError returns true for errors
+// that are retried by an I2CRetryPolicy.
+func IsTransientI2CError(err error) bool {
+	return errors.Is(err, syscall.EREMOTEIO) || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+func (i2c *I2C) RetryPolicy() *I2CRetryPolicy {
+	return i2c.retryPolicy
+}
+
+// SetRetryPolicy sets the policy for all following operations.
+// nil disables retries.
+func (i2c *I2C) SetRetryPolicy(policy *I2CRetryPolicy) {
+	i2c.retryPolicy = policy
+}
+
+func (i2c *I2C) retry(op func() error) error {
+	err := op()
+	policy := i2c.retryPolicy
+	if policy == nil {
+		return err
+	}
+	backoff := policy.Backoff
+	for i := 0; i < policy.Retries && IsTransientI2CError(err); i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = op()
+	}
+	return err
+}
+
+func (i2c *I2C) ioctl(function string, request, arg uintptr) error {
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), request, arg)
+	if int(result) == -1 {
+		return ErrI2C{function, errno}
+	}
+	return nil
+}
+
+// SetPEC enables or disables SMBus Packet Error Checking.
+// With PEC the kernel appends a CRC-8 to all SMBus writes and
+// verifies it for all SMBus reads, a mismatch is returned as EBADMSG.
+// Plain Read, Write and Transfer are not affected,
+// use ReadBlockPEC or SMBusPEC for them.
+func (i2c *I2C) SetPEC(enable bool) error {
+	var arg uintptr
+	if enable {
+		arg = 1
+	}
+	err := i2c.ioctl("SetPEC", C.I2C_PEC, arg)
+	if err != nil {
+		return err
+	}
+	i2c.pec = enable
+	return nil
+}
+
+func (i2c *I2C) PEC() bool {
+	return i2c.pec
+}
+
+// SetRetries sets how often the adapter repeats a transfer
+// if the device does not acknowledge its address.
+// Unlike SetRetryPolicy this is handled by the kernel driver
+// and not supported by all adapters.
+func (i2c *I2C) SetRetries(retries int) error {
+	return i2c.ioctl("SetRetries", C.I2C_RETRIES, uintptr(retries))
+}
+
+// SetTimeout sets the timeout of the adapter for a transfer.
+// The kernel uses units of 10 milliseconds.
+func (i2c *I2C) SetTimeout(timeout time.Duration) error {
+	units := (timeout + 10*time.Millisecond - 1) / (10 * time.Millisecond)
+	if units < 1 {
+		return wrapErr("SetTimeout", fmt.Errorf("Invalid timeout %s", timeout))
+	}
+	return i2c.ioctl("SetTimeout", C.I2C_TIMEOUT, uintptr(units))
+}
+
+// SMBusPEC returns the SMBus Packet Error Code, a CRC-8 with
+// polynomial x^8+x^2+x+1, of all bytes of a transaction including
+// the address bytes with the read/write bit.
+func SMBusPEC(data ...byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ReadBlockPEC is ReadBlock with PEC verification in software,
+// for adapters without SMBus PEC support.
+// It uses a Transfer with I2C_MSG_RECV_LEN, which requires the adapter
+// functionality I2C_FUNC_SMBUS_READ_BLOCK_DATA.
+// A PEC mismatch is returned as EBADMSG like the kernel does.
+func (i2c *I2C) ReadBlockPEC(register uint8) ([]byte, error) {
+	return readBlockPEC(i2c, i2c.address, register)
+}
+
+func readBlockPEC(bus I2CTransferer, address int, register uint8) ([]byte, error) {
+	addressByte := byte(address << 1)
+	data := make([]byte, 1+C.I2C_SMBUS_BLOCK_MAX+1)
+	// The kernel reads data[0] as number of bytes to read after the data
+	data[0] = 1
+	err := bus.Transfer([]I2CMessage{
+		{Address: address, Buf: []byte{register}},
+		{Address: address, Flags: I2C_MSG_READ | I2C_MSG_RECV_LEN, Buf: data},
+	})
+	if err != nil {
+		return nil, wrapErr("ReadBlockPEC", err)
+	}
+	count := int(data[0])
+	if count < 1 || count > C.I2C_SMBUS_BLOCK_MAX {
+		return nil, wrapErr("ReadBlockPEC", fmt.Errorf("Invalid block length %d", count))
+	}
+	pec := SMBusPEC(append([]byte{addressByte, register, addressByte | 1}, data[:1+count]...)...)
+	if pec != data[1+count] {
+		return nil, wrapErr("ReadBlockPEC", syscall.EBADMSG)
+	}
+	return data[1 : 1+count], nil
+}
+
+// SetRetryPolicy sets the retry policy for all devices of the bus.
+func (bus *I2CBus) SetRetryPolicy(policy *I2CRetryPolicy) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.i2c.SetRetryPolicy(policy)
+}
+
+// SetRetries sets the adapter retries, see I2C.SetRetries.
+func (bus *I2CBus) SetRetries(retries int) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.i2c.SetRetries(retries)
+}
+
+// SetTimeout sets the adapter timeout, see I2C.SetTimeout.
+func (bus *I2CBus) SetTimeout(timeout time.Duration) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.i2c.SetTimeout(timeout)
+}
+
+// SetPEC enables or disables SMBus Packet Error Checking
+// for the transactions of the device, see I2C.SetPEC.
+// It must not be called concurrently with other methods of the device.
+func (dev *I2CDevice) SetPEC(enable bool) {
+	dev.pec = enable
+}
+
+func (dev *I2CDevice) PEC() bool {
+	return dev.pec
+}
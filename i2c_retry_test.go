@@ -0,0 +1,136 @@
+package bbio
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSMBusPEC(t *testing.T) {
+	if pec := SMBusPEC([]byte("123456789")...); pec != 0xF4 {
+		t.Errorf("PEC of check string is 0x%02X instead of 0xF4", pec)
+	}
+	if pec := SMBusPEC(); pec != 0 {
+		t.Errorf("PEC without data is 0x%02X", pec)
+	}
+	// The PEC of data followed by its PEC is zero
+	data := []byte{0x50 << 1, 0x10, 0x50<<1 | 1, 0x02, 0xAB, 0xCD}
+	if pec := SMBusPEC(append(data, SMBusPEC(data...))...); pec != 0 {
+		t.Errorf("PEC including PEC is 0x%02X", pec)
+	}
+}
+
+func TestI2CRetry(t *testing.T) {
+	i2c := &I2C{address: -1}
+	errNAK := ErrI2C{"Tx", syscall.EREMOTEIO}
+	tests := []struct {
+		policy *I2CRetryPolicy
+		errs   []error
+		calls  int
+		err    error
+	}{
+		{nil, []error{errNAK, nil}, 1, errNAK},
+		{&I2CRetryPolicy{Retries: 2}, []error{errNAK, nil}, 2, nil},
+		{&I2CRetryPolicy{Retries: 2}, []error{errNAK, errNAK, errNAK, nil}, 3, errNAK},
+		{&I2CRetryPolicy{Retries: 2}, []error{ErrI2C{"Tx", syscall.ETIMEDOUT}, nil}, 2, nil},
+		// Other errors are not retried
+		{&I2CRetryPolicy{Retries: 2}, []error{ErrI2C{"Tx", syscall.EINVAL}, nil}, 1, ErrI2C{"Tx", syscall.EINVAL}},
+	}
+	for i, test := range tests {
+		i2c.SetRetryPolicy(test.policy)
+		calls := 0
+		err := i2c.retry(func() error {
+			calls++
+			return test.errs[calls-1]
+		})
+		if calls != test.calls || err != test.err {
+			t.Errorf("test %d: %d calls returned %v instead of %d calls returning %v", i, calls, err, test.calls, test.err)
+		}
+	}
+
+	i2c.SetRetryPolicy(&I2CRetryPolicy{Retries: 2, Backoff: 10 * time.Millisecond})
+	start := time.Now()
+	i2c.retry(func() error { return errNAK })
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("retries with doubled backoff took only %s", d)
+	}
+}
+
+func TestIsTransientI2CError(t *testing.T) {
+	for _, err := range []error{syscall.EREMOTEIO, ErrI2C{"Tx", syscall.ETIMEDOUT}} {
+		if !IsTransientI2CError(err) {
+			t.Errorf("%v is not transient", err)
+		}
+	}
+	for _, err := range []error{nil, syscall.ENXIO, errors.New("other")} {
+		if IsTransientI2CError(err) {
+			t.Errorf("%v is transient", err)
+		}
+	}
+}
+
+// smbusBlockFakeDevice answers I2C_MSG_RECV_LEN reads
+// with the byte count, the block and a PEC like the kernel does.
+type smbusBlockFakeDevice struct {
+	address  int
+	block    []byte
+	count    int  // sent as byte count if not zero
+	wrongPEC bool // corrupt the PEC
+	register byte
+}
+
+func (dev *smbusBlockFakeDevice) Transfer(messages []I2CMessage) error {
+	for _, message := range messages {
+		if message.Address != dev.address {
+			return ErrI2C{"Transfer", syscall.ENXIO}
+		}
+		if message.Flags&I2C_MSG_READ == 0 {
+			dev.register = message.Buf[0]
+			continue
+		}
+		if message.Flags&I2C_MSG_RECV_LEN == 0 || message.Buf[0] != 1 {
+			return ErrI2C{"Transfer", syscall.EINVAL}
+		}
+		count := len(dev.block)
+		if dev.count != 0 {
+			count = dev.count
+		}
+		address := byte(dev.address << 1)
+		response := append([]byte{byte(count)}, dev.block...)
+		pec := SMBusPEC(append([]byte{address, dev.register, address | 1}, response...)...)
+		if dev.wrongPEC {
+			pec ^= 0x01
+		}
+		copy(message.Buf, append(response, pec))
+	}
+	return nil
+}
+
+func TestReadBlockPEC(t *testing.T) {
+	dev := &smbusBlockFakeDevice{address: 0x0B, block: []byte{0x12, 0x34, 0x56}}
+	block, err := readBlockPEC(dev, 0x0B, 0x20)
+	if err != nil || !bytes.Equal(block, dev.block) || dev.register != 0x20 {
+		t.Errorf("ReadBlockPEC returned % X, %v from register 0x%02X", block, err, dev.register)
+	}
+
+	dev.wrongPEC = true
+	_, err = readBlockPEC(dev, 0x0B, 0x20)
+	if !errors.Is(err, syscall.EBADMSG) {
+		t.Errorf("ReadBlockPEC with wrong PEC returned %v instead of EBADMSG", err)
+	}
+
+	dev.wrongPEC = false
+	for _, count := range []int{-1, 33} {
+		dev.count = count
+		if _, err = readBlockPEC(dev, 0x0B, 0x20); err == nil {
+			t.Errorf("expected error for byte count %d", count)
+		}
+	}
+
+	_, err = readBlockPEC(dev, 0x0C, 0x20)
+	if !errors.Is(err, ErrI2CNAK) {
+		t.Errorf("ReadBlockPEC without device returned %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package bbio
+
+// #include <linux/i2c-dev.h>
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+// Address range probed by ScanI2C, like i2cdetect without -a
+const (
+	I2C_SCAN_FIRST_ADDRESS = 0x03
+	I2C_SCAN_LAST_ADDRESS  = 0x77
+)
+
+// I2CChip describes a commonly used chip for ScanI2C and IdentifyI2C.
+type I2CChip struct {
+	Name        string
+	Description string
+	Addresses   []int
+	// If HasID, the register IDRegister of the chip reads as ID
+	HasID      bool
+	IDRegister uint8
+	ID         uint8
+}
+
+// KnownI2CChips is used to suggest drivers for found devices.
+var KnownI2CChips = []I2CChip{
+	{"BMP180", "Pressure and temperature sensor", []int{0x77}, true, 0xD0, 0x55},
+	{"BMP280", "Pressure and temperature sensor", []int{0x76, 0x77}, true, 0xD0, 0x58},
+	{"BME280", "Humidity, pressure and temperature sensor", []int{0x76, 0x77}, true, 0xD0, 0x60},
+	{"MPU6050", "Accelerometer and gyroscope", []int{0x68, 0x69}, true, 0x75, 0x68},
+	{"MPU9250", "Accelerometer, gyroscope and magnetometer", []int{0x68, 0x69}, true, 0x75, 0x71},
+	{"ADXL345", "Accelerometer", []int{0x1D, 0x53}, true, 0x00, 0xE5},
+	{"L3GD20", "Gyroscope", []int{0x6A, 0x6B}, true, 0x0F, 0xD4},
+	{"L3GD20H", "Gyroscope", []int{0x6A, 0x6B}, true, 0x0F, 0xD7},
+	{"HMC5883L", "Magnetometer", []int{0x1E}, true, 0x0A, 0x48},
+	{"CCS811", "Air quality sensor", []int{0x5A, 0x5B}, true, 0x20, 0x81},
+	{"VL53L0X", "Time of flight distance sensor", []int{0x29}, true, 0xC0, 0xEE},
+	{"DS1307/DS3231", "Real time clock", []int{0x68}, false, 0, 0},
+	{"PCF8574", "8 bit I/O expander", []int{0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27}, false, 0, 0},
+	{"PCF8574A", "8 bit I/O expander", []int{0x38, 0x39, 0x3A, 0x3B, 0x3C, 0x3D, 0x3E, 0x3F}, false, 0, 0},
+	{"MCP23008/MCP23017", "8/16 bit I/O expander", []int{0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27}, false, 0, 0},
+	{"ADS1015/ADS1115", "Analog digital converter", []int{0x48, 0x49, 0x4A, 0x4B}, false, 0, 0},
+	{"TMP102", "Temperature sensor", []int{0x48, 0x49, 0x4A, 0x4B}, false, 0, 0},
+	{"PCA9685", "16 channel PWM driver", []int{0x40}, false, 0, 0},
+	{"HTU21D/SI7021", "Humidity and temperature sensor", []int{0x40}, false, 0, 0},
+	{"SSD1306", "OLED display controller", []int{0x3C, 0x3D}, false, 0, 0},
+	{"TSL2561", "Light sensor", []int{0x29, 0x39, 0x49}, false, 0, 0},
+	{"24Cxx", "EEPROM", []int{0x50, 0x51, 0x52, 0x53, 0x54, 0x55, 0x56, 0x57}, false, 0, 0},
+}
+
+// I2CChipsByAddress returns the known chips that can have address.
+func I2CChipsByAddress(address int) []I2CChip {
+	var chips []I2CChip
+	for _, chip := range KnownI2CChips {
+		for _, a := range chip.Addresses {
+			if a == address {
+				chips = append(chips, chip)
+				break
+			}
+		}
+	}
+	return chips
+}
+
+// I2CScanResult is a device found by ScanI2C.
+type I2CScanResult struct {
+	Address int
+	// Busy is true if the address is claimed by a kernel driver,
+	// shown as UU by i2cdetect. The device was not probed.
+	Busy bool
+	// Driver is the name of the kernel driver if Busy
+	Driver string
+	// Chips are the known chips that can have the address
+	Chips []I2CChip
+}
+
+// ScanI2C probes the addresses I2C_SCAN_FIRST_ADDRESS to I2C_SCAN_LAST_ADDRESS
+// of bus like i2cdetect and returns the found devices.
+// Like i2cdetect, addresses of EEPROMs and write protected chips
+// (0x30-0x37 and 0x50-0x5F) are probed with a byte read
+// and all other addresses with a quick write.
+// Use IdentifyI2C to check the chip ID of a found device.
+func ScanI2C(bus int) ([]I2CScanResult, error) {
+	i2c, err := openI2C(bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2c.Close()
+
+	funcs, err := i2c.Functionality()
+	if err != nil {
+		return nil, err
+	}
+	canQuick := funcs.Has(I2C_FUNC_SMBUS_QUICK)
+	canReadByte := funcs.Has(I2C_FUNC_SMBUS_READ_BYTE)
+	if !canQuick && !canReadByte {
+		return nil, fmt.Errorf("I2C bus %d supports neither quick write nor read byte", bus)
+	}
+
+	var results []I2CScanResult
+	for address := I2C_SCAN_FIRST_ADDRESS; address <= I2C_SCAN_LAST_ADDRESS; address++ {
+		// Not SetAddress, because i2cdetect probes reserved addresses too
+		err = i2c.setAddress(address, false, false)
+		if errors.Is(err, syscall.EBUSY) {
+			results = append(results, I2CScanResult{
+				Address: address,
+				Busy:    true,
+				Driver:  i2cDriverName(bus, address),
+				Chips:   I2CChipsByAddress(address),
+			})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		readByte := (address >= 0x30 && address <= 0x37) || (address >= 0x50 && address <= 0x5F)
+		if (readByte && canReadByte) || !canQuick {
+			_, err = i2c.ReadUint8()
+		} else {
+			err = i2c.WriteQuick(C.I2C_SMBUS_WRITE)
+		}
+		if err == nil {
+			results = append(results, I2CScanResult{
+				Address: address,
+				Chips:   I2CChipsByAddress(address),
+			})
+		}
+	}
+	return results, nil
+}
+
+// IdentifyI2C reads the ID registers of the known chips that can have
+// address and returns the chips whose ID matches.
+// Reading registers may change the state of unknown devices.
+func IdentifyI2C(bus, address int) ([]I2CChip, error) {
+	i2c, err := openI2C(bus)
+	if err != nil {
+		return nil, err
+	}
+	defer i2c.Close()
+
+	// Not NewI2C, because ScanI2C finds devices at reserved addresses too
+	err = i2c.setAddress(address, false, false)
+	if err != nil {
+		return nil, wrapErr("SetAddress", err)
+	}
+
+	var chips []I2CChip
+	for _, chip := range I2CChipsByAddress(address) {
+		if !chip.HasID {
+			continue
+		}
+		id, err := i2c.ReadUint8Reg(chip.IDRegister)
+		if err == nil && id == chip.ID {
+			chips = append(chips, chip)
+		}
+	}
+	return chips, nil
+}
+
+// i2cDriverName returns the kernel driver bound to address of bus.
+func i2cDriverName(bus, address int) string {
+	link, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/bus/i2c/devices/%d-%04x/driver", bus, address))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(link)
+}
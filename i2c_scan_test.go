@@ -0,0 +1,16 @@
+package bbio
+
+import "testing"
+
+func TestI2CChipsByAddress(t *testing.T) {
+	var names []string
+	for _, chip := range I2CChipsByAddress(0x77) {
+		names = append(names, chip.Name)
+	}
+	if len(names) != 3 || names[0] != "BMP180" || names[1] != "BMP280" || names[2] != "BME280" {
+		t.Errorf("chips at 0x77: %v", names)
+	}
+	if chips := I2CChipsByAddress(0x03); len(chips) != 0 {
+		t.Errorf("chips at reserved address 0x03: %v", chips)
+	}
+}
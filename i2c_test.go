@@ -0,0 +1,73 @@
+package bbio
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestI2CBlockLengthValidation(t *testing.T) {
+	// The SMBus block size limits the length to 1 to 32 bytes
+	i2c := &I2C{address: -1}
+	for _, length := range []int{0, -1, 33} {
+		_, err := i2c.ReadI2CBlock(0x10, length)
+		var errI2C ErrI2C
+		if !errors.As(err, &errI2C) || errI2C.function != "ReadI2CBlock" {
+			t.Errorf("ReadI2CBlock of %d bytes returned %v", length, err)
+		}
+	}
+	for _, length := range []int{0, 33} {
+		err := i2c.WriteI2CBlock(0x10, make([]byte, length))
+		var errI2C ErrI2C
+		if !errors.As(err, &errI2C) || errI2C.function != "WriteI2CBlock" {
+			t.Errorf("WriteI2CBlock of %d bytes returned %v", length, err)
+		}
+	}
+}
+
+func TestErrI2CIs(t *testing.T) {
+	tests := []struct {
+		errno  syscall.Errno
+		target error
+	}{
+		{syscall.ENXIO, ErrI2CNAK},
+		{syscall.EREMOTEIO, ErrI2CNAK},
+		{syscall.EAGAIN, ErrI2CArbitrationLost},
+		{syscall.ETIMEDOUT, ErrI2CTimeout},
+		{syscall.EOPNOTSUPP, ErrI2CUnsupported},
+		{syscall.EPROTONOSUPPORT, ErrI2CUnsupported},
+	}
+	targets := []error{ErrI2CNAK, ErrI2CArbitrationLost, ErrI2CTimeout, ErrI2CUnsupported}
+	for _, test := range tests {
+		err := wrapErr("Tx", test.errno)
+		for _, target := range targets {
+			if is := errors.Is(err, target); is != (target == test.target) {
+				t.Errorf("errors.Is(%v, %v) is %t", err, target, is)
+			}
+		}
+		if !errors.Is(err, test.errno) {
+			t.Errorf("%v does not unwrap to %v", err, test.errno)
+		}
+	}
+	if errors.Is(ErrI2C{"Tx", errors.New("other")}, ErrI2CNAK) {
+		t.Error("error without errno is ErrI2CNAK")
+	}
+
+	err := wrapErr("Transfer", ErrI2C{"SetAddress", syscall.ENXIO})
+	if err.Error() != "I2C.Transfer error: "+syscall.ENXIO.Error() {
+		t.Errorf("wrapped error message '%s'", err)
+	}
+	if wrapErr("Tx", nil) != nil {
+		t.Error("wrapErr of nil is not nil")
+	}
+}
+
+func TestI2CFunctionalityHas(t *testing.T) {
+	funcs := I2C_FUNC_SMBUS_QUICK | I2C_FUNC_SMBUS_READ_BYTE
+	if !funcs.Has(I2C_FUNC_SMBUS_QUICK) || !funcs.Has(I2C_FUNC_SMBUS_QUICK|I2C_FUNC_SMBUS_READ_BYTE) {
+		t.Error("Has false for supported functionality")
+	}
+	if funcs.Has(I2C_FUNC_SMBUS_QUICK | I2C_FUNC_SMBUS_WRITE_I2C_BLOCK) {
+		t.Error("Has true for partly supported functionality")
+	}
+}
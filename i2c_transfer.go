@@ -0,0 +1,104 @@
+package bbio
+
+// #include <linux/i2c-dev.h>
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// I2CMessageFlags of I2CMessage
+type I2CMessageFlags uint16
+
+// Values of I2CMessageFlags, same as I2C_M_* of the kernel
+const (
+	I2C_MSG_READ       I2CMessageFlags = 0x0001 // Read data from the device into Buf
+	I2C_MSG_TEN_BIT    I2CMessageFlags = 0x0010 // Address is a 10 bit address
+	I2C_MSG_RECV_LEN   I2CMessageFlags = 0x0400 // First received byte is the length of the following data
+	I2C_MSG_IGNORE_NAK I2CMessageFlags = 0x1000 // Treat a NAK of the device as ACK
+	I2C_MSG_NO_START   I2CMessageFlags = 0x4000 // No repeated start and address before this message
+)
+
+// I2C_RDWR_MAX_MESSAGES is the maximum number of messages of a Transfer.
+const I2C_RDWR_MAX_MESSAGES = 42
+
+// I2CMessage is one segment of a combined I2C transaction, see I2C.Transfer.
+type I2CMessage struct {
+	Address int
+	Flags   I2CMessageFlags
+	// Buf holds the data to write or receives the data read.
+	Buf []byte
+}
+
+// i2cMsg has the memory layout of struct i2c_msg
+type i2cMsg struct {
+	addr  uint16
+	flags uint16
+	len   uint16
+	buf   unsafe.Pointer
+}
+
+// i2cRdwrIoctlData has the memory layout of struct i2c_rdwr_ioctl_data
+type i2cRdwrIoctlData struct {
+	msgs  unsafe.Pointer
+	nmsgs uint32
+}
+
+// Transfer executes messages as one combined transaction with the I2C_RDWR ioctl.
+// The messages are separated by repeated starts without a stop in between,
+// so no other master can access the bus during the transaction.
+// Unlike the SMBus methods the messages are not limited to 32 bytes
+// and don't use the address set with SetAddress.
+func (i2c *I2C) Transfer(messages []I2CMessage) error {
+	if len(messages) == 0 || len(messages) > I2C_RDWR_MAX_MESSAGES {
+		return wrapErr("Transfer", fmt.Errorf("Number of messages is %d, but must be in the range 1 to %d", len(messages), I2C_RDWR_MAX_MESSAGES))
+	}
+	msgs := make([]i2cMsg, len(messages))
+	for i, message := range messages {
+		if len(message.Buf) > 0xFFFF {
+			return wrapErr("Transfer", fmt.Errorf("Length of message %d is %d, but must not exceed %d", i, len(message.Buf), 0xFFFF))
+		}
+		msgs[i].addr = uint16(message.Address)
+		msgs[i].flags = uint16(message.Flags)
+		msgs[i].len = uint16(len(message.Buf))
+		if len(message.Buf) > 0 {
+			msgs[i].buf = unsafe.Pointer(&message.Buf[0])
+		}
+	}
+	data := i2cRdwrIoctlData{
+		msgs:  unsafe.Pointer(&msgs[0]),
+		nmsgs: uint32(len(msgs)),
+	}
+	err := i2c.retry(func() error {
+		result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_RDWR, uintptr(unsafe.Pointer(&data)))
+		if int(result) == -1 {
+			return errno
+		}
+		return nil
+	})
+	return wrapErr("Transfer", err)
+}
+
+// Tx writes w to the device at the address set with SetAddress,
+// SetTenBitAddress or ForceAddress
+// and then reads len(r) bytes into r with a repeated start in between.
+// If w or r is empty only the other message is transferred.
+func (i2c *I2C) Tx(w, r []byte) error {
+	var flags I2CMessageFlags
+	if i2c.tenBit {
+		flags = I2C_MSG_TEN_BIT
+	}
+	var messages []I2CMessage
+	if len(w) > 0 {
+		messages = append(messages, I2CMessage{Address: i2c.address, Flags: flags, Buf: w})
+	}
+	if len(r) > 0 {
+		messages = append(messages, I2CMessage{Address: i2c.address, Flags: flags | I2C_MSG_READ, Buf: r})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return wrapErr("Tx", i2c.Transfer(messages))
+}
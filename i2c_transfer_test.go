@@ -0,0 +1,29 @@
+package bbio
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestI2CTransferValidation(t *testing.T) {
+	i2c := &I2C{address: -1}
+	tests := []struct {
+		name     string
+		messages []I2CMessage
+	}{
+		{"no messages", nil},
+		{"too many messages", make([]I2CMessage, I2C_RDWR_MAX_MESSAGES+1)},
+		{"too long message", []I2CMessage{{Address: 0x50}, {Address: 0x50, Flags: I2C_MSG_READ, Buf: make([]byte, 0x10000)}}},
+	}
+	for _, test := range tests {
+		err := i2c.Transfer(test.messages)
+		var errI2C ErrI2C
+		if !errors.As(err, &errI2C) || errI2C.function != "Transfer" {
+			t.Errorf("%s: Transfer returned %v", test.name, err)
+		}
+	}
+
+	if err := i2c.Tx(nil, nil); err != nil {
+		t.Errorf("Tx without data returned %v", err)
+	}
+}
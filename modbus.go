@@ -0,0 +1,227 @@
+package bbio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Modbus function codes
+const (
+	MODBUS_READ_COILS                    uint8 = 1
+	MODBUS_READ_DISCRETE_INPUTS          uint8 = 2
+	MODBUS_READ_HOLDING_REGISTERS        uint8 = 3
+	MODBUS_READ_INPUT_REGISTERS          uint8 = 4
+	MODBUS_WRITE_SINGLE_COIL             uint8 = 5
+	MODBUS_WRITE_SINGLE_REGISTER         uint8 = 6
+	MODBUS_WRITE_MULTIPLE_COILS          uint8 = 15
+	MODBUS_WRITE_MULTIPLE_REGISTERS      uint8 = 16
+	MODBUS_READ_WRITE_MULTIPLE_REGISTERS uint8 = 23
+)
+
+// Limits of the Modbus specification for a single request
+const (
+	MODBUS_MAX_READ_BITS          = 2000
+	MODBUS_MAX_WRITE_BITS         = 1968
+	MODBUS_MAX_READ_REGISTERS     = 125
+	MODBUS_MAX_WRITE_REGISTERS    = 123
+	MODBUS_MAX_RW_WRITE_REGISTERS = 121
+)
+
+const (
+	modbusBroadcastAddress = 0
+	modbusMaxFrameSize     = 256
+)
+
+// ModbusException is the exception code of a Modbus exception response.
+// It is returned as error by ModbusMaster and can be returned
+// by a ModbusHandler to send an exception response.
+type ModbusException uint8
+
+const (
+	MODBUS_EXCEPTION_ILLEGAL_FUNCTION         ModbusException = 0x01
+	MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS     ModbusException = 0x02
+	MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE       ModbusException = 0x03
+	MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE    ModbusException = 0x04
+	MODBUS_EXCEPTION_ACKNOWLEDGE              ModbusException = 0x05
+	MODBUS_EXCEPTION_SERVER_DEVICE_BUSY       ModbusException = 0x06
+	MODBUS_EXCEPTION_GATEWAY_PATH_UNAVAILABLE ModbusException = 0x0A
+	MODBUS_EXCEPTION_GATEWAY_TARGET_FAILED    ModbusException = 0x0B
+)
+
+func (e ModbusException) Error() string {
+	switch e {
+	case MODBUS_EXCEPTION_ILLEGAL_FUNCTION:
+		return "Modbus exception: illegal function"
+	case MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS:
+		return "Modbus exception: illegal data address"
+	case MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE:
+		return "Modbus exception: illegal data value"
+	case MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE:
+		return "Modbus exception: server device failure"
+	case MODBUS_EXCEPTION_ACKNOWLEDGE:
+		return "Modbus exception: acknowledge"
+	case MODBUS_EXCEPTION_SERVER_DEVICE_BUSY:
+		return "Modbus exception: server device busy"
+	case MODBUS_EXCEPTION_GATEWAY_PATH_UNAVAILABLE:
+		return "Modbus exception: gateway path unavailable"
+	case MODBUS_EXCEPTION_GATEWAY_TARGET_FAILED:
+		return "Modbus exception: gateway target device failed to respond"
+	}
+	return fmt.Sprintf("Modbus exception: code 0x%02X", uint8(e))
+}
+
+var (
+	ErrModbusTimeout  = errors.New("Modbus timeout")
+	ErrModbusCRC      = errors.New("Modbus CRC error")
+	ErrModbusResponse = errors.New("Modbus invalid response")
+	// ErrModbusBroadcastRead is returned for reads from the broadcast
+	// address 0, because broadcast requests have no response.
+	ErrModbusBroadcastRead = errors.New("Modbus read from broadcast address")
+)
+
+// ModbusCRC16 returns the CRC-16/MODBUS checksum of data.
+// In a frame it is transmitted low byte first.
+func ModbusCRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ModbusSilence returns the minimum silent interval of 3.5 characters
+// between two Modbus RTU frames for baud.
+// Above 19200 baud the fixed value of 1.75 milliseconds is used,
+// which is also returned for invalid baud rates.
+func ModbusSilence(baud int) time.Duration {
+	if baud > 19200 || baud <= 0 {
+		return 1750 * time.Microsecond
+	}
+	// 11 bits per character
+	return 35 * 11 * time.Second / time.Duration(10*baud)
+}
+
+type modbusReadTimeouter interface {
+	SetReadTimeout(timeout time.Duration) error
+}
+
+// modbusPort reads and writes Modbus RTU frames.
+// The master delimits response frames by their length which is derived
+// from the function code, the slave delimits request frames
+// by the silent interval.
+type modbusPort struct {
+	rw      io.ReadWriter
+	silence time.Duration
+	lastIO  time.Time
+	buf     []byte // received but not yet consumed
+}
+
+func newModbusPort(rw io.ReadWriter, baud int) (modbusPort, error) {
+	if baud <= 0 {
+		return modbusPort{}, fmt.Errorf("Invalid Modbus baud rate %d", baud)
+	}
+	return modbusPort{
+		rw:      rw,
+		silence: ModbusSilence(baud),
+		buf:     make([]byte, 0, modbusMaxFrameSize),
+	}, nil
+}
+
+// fill reads until at least n bytes are buffered.
+// A read of zero bytes or a read timeout returns ErrModbusTimeout.
+func (port *modbusPort) fill(n int) error {
+	if n > modbusMaxFrameSize {
+		return ErrModbusResponse
+	}
+	for len(port.buf) < n {
+		count, err := port.rw.Read(port.buf[len(port.buf):cap(port.buf)])
+		port.buf = port.buf[:len(port.buf)+count]
+		if count > 0 {
+			port.lastIO = time.Now()
+			continue
+		}
+		if err == nil || err == io.EOF || IsTimeout(err) {
+			return ErrModbusTimeout
+		}
+		return err
+	}
+	return nil
+}
+
+// consume removes the first n bytes from the buffer.
+func (port *modbusPort) consume(n int) {
+	port.buf = port.buf[:copy(port.buf, port.buf[n:])]
+}
+
+// checkFrame returns ErrModbusCRC if the first n buffered bytes
+// are no valid frame.
+func (port *modbusPort) checkFrame(n int) error {
+	crc := ModbusCRC16(port.buf[:n-2])
+	if port.buf[n-2] != byte(crc) || port.buf[n-1] != byte(crc>>8) {
+		return ErrModbusCRC
+	}
+	return nil
+}
+
+// writeFrame waits for the silent interval and sends
+// address and pdu followed by the CRC.
+func (port *modbusPort) writeFrame(address uint8, pdu []byte) error {
+	frame := make([]byte, 0, len(pdu)+3)
+	frame = append(frame, address)
+	frame = append(frame, pdu...)
+	crc := ModbusCRC16(frame)
+	frame = append(frame, byte(crc), byte(crc>>8))
+
+	time.Sleep(port.silence - time.Since(port.lastIO))
+	_, err := port.rw.Write(frame)
+	if uart, ok := port.rw.(*UART); ok && err == nil {
+		// The silent interval starts after the last byte left the UART
+		err = uart.Drain()
+	}
+	port.lastIO = time.Now()
+	return err
+}
+
+func modbusPackBits(values []bool) []byte {
+	data := make([]byte, (len(values)+7)/8)
+	for i, value := range values {
+		if value {
+			data[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return data
+}
+
+func modbusUnpackBits(data []byte, quantity int) []bool {
+	values := make([]bool, quantity)
+	for i := range values {
+		values[i] = data[i/8]&(1<<uint(i%8)) != 0
+	}
+	return values
+}
+
+func modbusPackRegisters(values []uint16) []byte {
+	data := make([]byte, len(values)*2)
+	for i, value := range values {
+		data[i*2] = byte(value >> 8)
+		data[i*2+1] = byte(value)
+	}
+	return data
+}
+
+func modbusUnpackRegisters(data []byte) []uint16 {
+	values := make([]uint16, len(data)/2)
+	for i := range values {
+		values[i] = uint16(data[i*2])<<8 | uint16(data[i*2+1])
+	}
+	return values
+}
@@ -0,0 +1,242 @@
+package bbio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ModbusMaster sends Modbus RTU requests to slaves
+// over an UART or any other io.ReadWriter.
+//
+// Responses are only time limited if the io.ReadWriter
+// has a SetReadTimeout method like UART.
+type ModbusMaster struct {
+	port    modbusPort
+	timeout time.Duration
+}
+
+// NewModbusMaster returns a ModbusMaster for rw.
+// baud is used to calculate the silent interval between frames.
+func NewModbusMaster(rw io.ReadWriter, baud int) (*ModbusMaster, error) {
+	port, err := newModbusPort(rw, baud)
+	if err != nil {
+		return nil, err
+	}
+	master := &ModbusMaster{port: port}
+	err = master.SetTimeout(time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return master, nil
+}
+
+func (master *ModbusMaster) Timeout() time.Duration {
+	return master.timeout
+}
+
+// SetTimeout sets the time to wait for a response.
+func (master *ModbusMaster) SetTimeout(timeout time.Duration) error {
+	if t, ok := master.port.rw.(modbusReadTimeouter); ok {
+		err := t.SetReadTimeout(timeout)
+		if err != nil {
+			return err
+		}
+	}
+	master.timeout = timeout
+	return nil
+}
+
+// transaction sends the request pdu to slave and returns the pdu of the response.
+// Requests to the broadcast address 0 have no response.
+func (master *ModbusMaster) transaction(slave uint8, request []byte) (response []byte, err error) {
+	port := &master.port
+	// Data received before the request can't be part of the response
+	port.buf = port.buf[:0]
+
+	err = port.writeFrame(slave, request)
+	if err != nil {
+		return nil, err
+	}
+	if slave == modbusBroadcastAddress {
+		// Give the slaves time to process the request
+		time.Sleep(port.silence)
+		return nil, nil
+	}
+
+	function := request[0]
+	err = port.fill(3)
+	if err != nil {
+		return nil, err
+	}
+	if port.buf[0] != slave {
+		return nil, fmt.Errorf("%w: slave address %d instead of %d", ErrModbusResponse, port.buf[0], slave)
+	}
+
+	var length int
+	switch port.buf[1] {
+	case function | 0x80:
+		length = 5
+	case function:
+		switch function {
+		case MODBUS_WRITE_SINGLE_COIL, MODBUS_WRITE_SINGLE_REGISTER, MODBUS_WRITE_MULTIPLE_COILS, MODBUS_WRITE_MULTIPLE_REGISTERS:
+			length = 8
+		default:
+			length = 3 + int(port.buf[2]) + 2
+		}
+	default:
+		return nil, fmt.Errorf("%w: function code 0x%02X instead of 0x%02X", ErrModbusResponse, port.buf[1], function)
+	}
+
+	err = port.fill(length)
+	if err != nil {
+		return nil, err
+	}
+	err = port.checkFrame(length)
+	if err != nil {
+		return nil, err
+	}
+	response = append([]byte(nil), port.buf[1:length-2]...)
+	port.consume(length)
+
+	if response[0]&0x80 != 0 {
+		return nil, ModbusException(response[1])
+	}
+	return response, nil
+}
+
+func modbusRequest(function uint8, address, quantity uint16) []byte {
+	return []byte{function, byte(address >> 8), byte(address), byte(quantity >> 8), byte(quantity)}
+}
+
+// writeTransaction sends a write request, whose response
+// echoes function code, address and quantity or value of the request.
+func (master *ModbusMaster) writeTransaction(slave uint8, request []byte) error {
+	response, err := master.transaction(slave, request)
+	if err != nil || slave == modbusBroadcastAddress {
+		return err
+	}
+	if !bytes.Equal(response, request[:5]) {
+		return fmt.Errorf("%w: echo % X of request % X", ErrModbusResponse, response, request[:5])
+	}
+	return nil
+}
+
+func (master *ModbusMaster) readBits(slave, function uint8, address, quantity uint16) ([]bool, error) {
+	if quantity < 1 || quantity > MODBUS_MAX_READ_BITS {
+		return nil, fmt.Errorf("Modbus quantity %d not in range 1 to %d", quantity, MODBUS_MAX_READ_BITS)
+	}
+	if slave == modbusBroadcastAddress {
+		return nil, ErrModbusBroadcastRead
+	}
+	response, err := master.transaction(slave, modbusRequest(function, address, quantity))
+	if err != nil {
+		return nil, err
+	}
+	if int(response[1]) != (int(quantity)+7)/8 {
+		return nil, fmt.Errorf("%w: %d data bytes for %d bits", ErrModbusResponse, response[1], quantity)
+	}
+	return modbusUnpackBits(response[2:], int(quantity)), nil
+}
+
+func (master *ModbusMaster) readRegisters(slave, function uint8, address, quantity uint16) ([]uint16, error) {
+	if quantity < 1 || quantity > MODBUS_MAX_READ_REGISTERS {
+		return nil, fmt.Errorf("Modbus quantity %d not in range 1 to %d", quantity, MODBUS_MAX_READ_REGISTERS)
+	}
+	if slave == modbusBroadcastAddress {
+		return nil, ErrModbusBroadcastRead
+	}
+	response, err := master.transaction(slave, modbusRequest(function, address, quantity))
+	if err != nil {
+		return nil, err
+	}
+	if int(response[1]) != int(quantity)*2 {
+		return nil, fmt.Errorf("%w: %d data bytes for %d registers", ErrModbusResponse, response[1], quantity)
+	}
+	return modbusUnpackRegisters(response[2:]), nil
+}
+
+// ReadCoils reads quantity coils starting at address (function code 1).
+func (master *ModbusMaster) ReadCoils(slave uint8, address, quantity uint16) ([]bool, error) {
+	return master.readBits(slave, MODBUS_READ_COILS, address, quantity)
+}
+
+// ReadDiscreteInputs reads quantity discrete inputs starting at address (function code 2).
+func (master *ModbusMaster) ReadDiscreteInputs(slave uint8, address, quantity uint16) ([]bool, error) {
+	return master.readBits(slave, MODBUS_READ_DISCRETE_INPUTS, address, quantity)
+}
+
+// ReadHoldingRegisters reads quantity holding registers starting at address (function code 3).
+func (master *ModbusMaster) ReadHoldingRegisters(slave uint8, address, quantity uint16) ([]uint16, error) {
+	return master.readRegisters(slave, MODBUS_READ_HOLDING_REGISTERS, address, quantity)
+}
+
+// ReadInputRegisters reads quantity input registers starting at address (function code 4).
+func (master *ModbusMaster) ReadInputRegisters(slave uint8, address, quantity uint16) ([]uint16, error) {
+	return master.readRegisters(slave, MODBUS_READ_INPUT_REGISTERS, address, quantity)
+}
+
+// WriteSingleCoil writes one coil (function code 5).
+func (master *ModbusMaster) WriteSingleCoil(slave uint8, address uint16, value bool) error {
+	var data uint16
+	if value {
+		data = 0xFF00
+	}
+	return master.writeTransaction(slave, modbusRequest(MODBUS_WRITE_SINGLE_COIL, address, data))
+}
+
+// WriteSingleRegister writes one holding register (function code 6).
+func (master *ModbusMaster) WriteSingleRegister(slave uint8, address, value uint16) error {
+	return master.writeTransaction(slave, modbusRequest(MODBUS_WRITE_SINGLE_REGISTER, address, value))
+}
+
+// WriteMultipleCoils writes coils starting at address (function code 15).
+func (master *ModbusMaster) WriteMultipleCoils(slave uint8, address uint16, values []bool) error {
+	if len(values) < 1 || len(values) > MODBUS_MAX_WRITE_BITS {
+		return fmt.Errorf("Modbus quantity %d not in range 1 to %d", len(values), MODBUS_MAX_WRITE_BITS)
+	}
+	data := modbusPackBits(values)
+	request := modbusRequest(MODBUS_WRITE_MULTIPLE_COILS, address, uint16(len(values)))
+	request = append(request, byte(len(data)))
+	request = append(request, data...)
+	return master.writeTransaction(slave, request)
+}
+
+// WriteMultipleRegisters writes holding registers starting at address (function code 16).
+func (master *ModbusMaster) WriteMultipleRegisters(slave uint8, address uint16, values []uint16) error {
+	if len(values) < 1 || len(values) > MODBUS_MAX_WRITE_REGISTERS {
+		return fmt.Errorf("Modbus quantity %d not in range 1 to %d", len(values), MODBUS_MAX_WRITE_REGISTERS)
+	}
+	request := modbusRequest(MODBUS_WRITE_MULTIPLE_REGISTERS, address, uint16(len(values)))
+	request = append(request, byte(len(values)*2))
+	request = append(request, modbusPackRegisters(values)...)
+	return master.writeTransaction(slave, request)
+}
+
+// ReadWriteMultipleRegisters writes writeValues to the holding registers
+// at writeAddress and then reads readQuantity holding registers
+// from readAddress in one transaction (function code 23).
+func (master *ModbusMaster) ReadWriteMultipleRegisters(slave uint8, readAddress, readQuantity, writeAddress uint16, writeValues []uint16) ([]uint16, error) {
+	if readQuantity < 1 || readQuantity > MODBUS_MAX_READ_REGISTERS {
+		return nil, fmt.Errorf("Modbus read quantity %d not in range 1 to %d", readQuantity, MODBUS_MAX_READ_REGISTERS)
+	}
+	if len(writeValues) < 1 || len(writeValues) > MODBUS_MAX_RW_WRITE_REGISTERS {
+		return nil, fmt.Errorf("Modbus write quantity %d not in range 1 to %d", len(writeValues), MODBUS_MAX_RW_WRITE_REGISTERS)
+	}
+	if slave == modbusBroadcastAddress {
+		return nil, ErrModbusBroadcastRead
+	}
+	request := modbusRequest(MODBUS_READ_WRITE_MULTIPLE_REGISTERS, readAddress, readQuantity)
+	request = append(request, modbusRequest(0, writeAddress, uint16(len(writeValues)))[1:]...)
+	request = append(request, byte(len(writeValues)*2))
+	request = append(request, modbusPackRegisters(writeValues)...)
+	response, err := master.transaction(slave, request)
+	if err != nil {
+		return nil, err
+	}
+	if int(response[1]) != int(readQuantity)*2 {
+		return nil, fmt.Errorf("%w: %d data bytes for %d registers", ErrModbusResponse, response[1], readQuantity)
+	}
+	return modbusUnpackRegisters(response[2:]), nil
+}
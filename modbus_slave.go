@@ -0,0 +1,298 @@
+package bbio
+
+import (
+	"io"
+	"time"
+)
+
+// ModbusHandler implements the data model of a ModbusSlave.
+// Methods can return a ModbusException to send a specific
+// exception response, any other error results in
+// MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE.
+// The slave validates quantities, so the handler only has to
+// check the address range.
+type ModbusHandler interface {
+	ReadCoils(address, quantity uint16) ([]bool, error)
+	ReadDiscreteInputs(address, quantity uint16) ([]bool, error)
+	ReadHoldingRegisters(address, quantity uint16) ([]uint16, error)
+	ReadInputRegisters(address, quantity uint16) ([]uint16, error)
+	WriteCoils(address uint16, values []bool) error
+	WriteHoldingRegisters(address uint16, values []uint16) error
+}
+
+// ModbusSlave answers Modbus RTU requests for its address
+// over an UART or any other io.ReadWriter.
+type ModbusSlave struct {
+	port      modbusPort
+	address   uint8
+	handler   ModbusHandler
+	timeouter modbusReadTimeouter
+}
+
+// NewModbusSlave returns a ModbusSlave for address that calls handler.
+// Request frames end with a silent interval of ModbusSilence(baud).
+// If rw has a SetReadTimeout method like UART, it is used
+// to detect the silent interval, else every read timeout error
+// returned by rw ends a frame.
+func NewModbusSlave(rw io.ReadWriter, baud int, address uint8, handler ModbusHandler) (*ModbusSlave, error) {
+	port, err := newModbusPort(rw, baud)
+	if err != nil {
+		return nil, err
+	}
+	slave := &ModbusSlave{
+		port:    port,
+		address: address,
+		handler: handler,
+	}
+	slave.timeouter, _ = rw.(modbusReadTimeouter)
+	return slave, nil
+}
+
+func (slave *ModbusSlave) Address() uint8 {
+	return slave.address
+}
+
+// Serve handles requests until reading or writing fails.
+func (slave *ModbusSlave) Serve() error {
+	for {
+		err := slave.ServeRequest()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ServeRequest waits for the next valid request and answers it
+// if it is addressed to the slave.
+// Requests to the broadcast address 0 are executed without response.
+// Frames with a wrong CRC are discarded.
+func (slave *ModbusSlave) ServeRequest() error {
+	port := &slave.port
+	for {
+		err := slave.readFrame()
+		if err != nil {
+			return err
+		}
+		length := len(port.buf)
+		if length < 4 || port.checkFrame(length) != nil {
+			continue
+		}
+
+		address := port.buf[0]
+		request := append([]byte(nil), port.buf[1:length-2]...)
+		if address != slave.address && address != modbusBroadcastAddress {
+			continue
+		}
+
+		response := slave.handle(request)
+		if address == modbusBroadcastAddress {
+			return nil
+		}
+		return port.writeFrame(slave.address, response)
+	}
+}
+
+// readFrame reads the next frame into port.buf.
+// It waits without timeout for the first byte, the following bytes
+// belong to the frame until the silent interval.
+// A frame longer than modbusMaxFrameSize is returned empty.
+func (slave *ModbusSlave) readFrame() error {
+	port := &slave.port
+	port.buf = port.buf[:0]
+	err := slave.setReadTimeout(0)
+	if err != nil {
+		return err
+	}
+	overflow := false
+	for {
+		if len(port.buf) == cap(port.buf) {
+			// Too long for a frame, discard until the silence
+			overflow = true
+			port.buf = port.buf[:0]
+		}
+		n, err := port.rw.Read(port.buf[len(port.buf):cap(port.buf)])
+		if n > 0 {
+			if len(port.buf) == 0 && !overflow {
+				err = slave.setReadTimeout(port.silence)
+				if err != nil {
+					return err
+				}
+			}
+			port.buf = port.buf[:len(port.buf)+n]
+			port.lastIO = time.Now()
+			continue
+		}
+		frameStarted := len(port.buf) > 0 || overflow
+		switch {
+		case err == nil || IsTimeout(err):
+			if !frameStarted {
+				continue
+			}
+		case err == io.EOF && frameStarted:
+			// io.EOF is returned again by the next read
+		default:
+			return err
+		}
+		if overflow {
+			port.buf = port.buf[:0]
+		}
+		return nil
+	}
+}
+
+func (slave *ModbusSlave) setReadTimeout(timeout time.Duration) error {
+	if slave.timeouter == nil {
+		return nil
+	}
+	return slave.timeouter.SetReadTimeout(timeout)
+}
+
+func modbusExceptionResponse(function uint8, err error) []byte {
+	exception, ok := err.(ModbusException)
+	if !ok {
+		exception = MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE
+	}
+	return []byte{function | 0x80, byte(exception)}
+}
+
+// modbusRequestHeaderSize returns the size of the request pdu
+// without the data bytes, or zero for unsupported function codes.
+func modbusRequestHeaderSize(function uint8) int {
+	switch function {
+	case MODBUS_READ_COILS, MODBUS_READ_DISCRETE_INPUTS, MODBUS_READ_HOLDING_REGISTERS, MODBUS_READ_INPUT_REGISTERS,
+		MODBUS_WRITE_SINGLE_COIL, MODBUS_WRITE_SINGLE_REGISTER:
+		return 5
+	case MODBUS_WRITE_MULTIPLE_COILS, MODBUS_WRITE_MULTIPLE_REGISTERS:
+		return 6 // including the byte count
+	case MODBUS_READ_WRITE_MULTIPLE_REGISTERS:
+		return 10 // including the byte count
+	}
+	return 0
+}
+
+// handle calls the handler for the request pdu and returns the response pdu.
+// The request comes from a valid frame of at least one byte,
+// but its content must not be trusted.
+func (slave *ModbusSlave) handle(request []byte) []byte {
+	function := request[0]
+	headerSize := modbusRequestHeaderSize(function)
+	if headerSize == 0 {
+		return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_FUNCTION)
+	}
+	if len(request) < headerSize {
+		return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+	}
+	if headerSize > 5 && len(request) != headerSize+int(request[headerSize-1]) {
+		// The byte count doesn't match the data
+		return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+	}
+	address := uint16(request[1])<<8 | uint16(request[2])
+	quantity := uint16(request[3])<<8 | uint16(request[4])
+
+	switch function {
+	case MODBUS_READ_COILS, MODBUS_READ_DISCRETE_INPUTS:
+		if quantity < 1 || quantity > MODBUS_MAX_READ_BITS {
+			return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+		}
+		var values []bool
+		var err error
+		if function == MODBUS_READ_COILS {
+			values, err = slave.handler.ReadCoils(address, quantity)
+		} else {
+			values, err = slave.handler.ReadDiscreteInputs(address, quantity)
+		}
+		if err == nil && len(values) != int(quantity) {
+			err = MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE
+		}
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		data := modbusPackBits(values)
+		return append([]byte{function, byte(len(data))}, data...)
+
+	case MODBUS_READ_HOLDING_REGISTERS, MODBUS_READ_INPUT_REGISTERS:
+		if quantity < 1 || quantity > MODBUS_MAX_READ_REGISTERS {
+			return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+		}
+		var values []uint16
+		var err error
+		if function == MODBUS_READ_HOLDING_REGISTERS {
+			values, err = slave.handler.ReadHoldingRegisters(address, quantity)
+		} else {
+			values, err = slave.handler.ReadInputRegisters(address, quantity)
+		}
+		if err == nil && len(values) != int(quantity) {
+			err = MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE
+		}
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		return append([]byte{function, byte(quantity * 2)}, modbusPackRegisters(values)...)
+
+	case MODBUS_WRITE_SINGLE_COIL:
+		// quantity is the output value
+		if quantity != 0xFF00 && quantity != 0x0000 {
+			return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+		}
+		err := slave.handler.WriteCoils(address, []bool{quantity == 0xFF00})
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		return request
+
+	case MODBUS_WRITE_SINGLE_REGISTER:
+		// quantity is the register value
+		err := slave.handler.WriteHoldingRegisters(address, []uint16{quantity})
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		return request
+
+	case MODBUS_WRITE_MULTIPLE_COILS:
+		data := request[6:]
+		if quantity < 1 || quantity > MODBUS_MAX_WRITE_BITS || int(request[5]) != (int(quantity)+7)/8 {
+			return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+		}
+		err := slave.handler.WriteCoils(address, modbusUnpackBits(data, int(quantity)))
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		return request[:5]
+
+	case MODBUS_WRITE_MULTIPLE_REGISTERS:
+		data := request[6:]
+		if quantity < 1 || quantity > MODBUS_MAX_WRITE_REGISTERS || int(request[5]) != int(quantity)*2 {
+			return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+		}
+		err := slave.handler.WriteHoldingRegisters(address, modbusUnpackRegisters(data))
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		return request[:5]
+
+	case MODBUS_READ_WRITE_MULTIPLE_REGISTERS:
+		writeAddress := uint16(request[5])<<8 | uint16(request[6])
+		writeQuantity := uint16(request[7])<<8 | uint16(request[8])
+		data := request[10:]
+		if quantity < 1 || quantity > MODBUS_MAX_READ_REGISTERS ||
+			writeQuantity < 1 || writeQuantity > MODBUS_MAX_RW_WRITE_REGISTERS ||
+			int(request[9]) != int(writeQuantity)*2 {
+			return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_DATA_VALUE)
+		}
+		// The write operation is performed before the read
+		err := slave.handler.WriteHoldingRegisters(writeAddress, modbusUnpackRegisters(data))
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		values, err := slave.handler.ReadHoldingRegisters(address, quantity)
+		if err == nil && len(values) != int(quantity) {
+			err = MODBUS_EXCEPTION_SERVER_DEVICE_FAILURE
+		}
+		if err != nil {
+			return modbusExceptionResponse(function, err)
+		}
+		return append([]byte{function, byte(quantity * 2)}, modbusPackRegisters(values)...)
+	}
+
+	return modbusExceptionResponse(function, MODBUS_EXCEPTION_ILLEGAL_FUNCTION)
+}
@@ -0,0 +1,411 @@
+package bbio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestModbusCRC16(t *testing.T) {
+	if crc := ModbusCRC16([]byte("123456789")); crc != 0x4B37 {
+		t.Errorf("CRC of check string is 0x%04X instead of 0x4B37", crc)
+	}
+	// Read holding registers request from the Modbus specification examples
+	if crc := ModbusCRC16([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0A}); crc != 0xCDC5 {
+		t.Errorf("CRC of request is 0x%04X instead of 0xCDC5", crc)
+	}
+}
+
+func TestModbusSilence(t *testing.T) {
+	if d := ModbusSilence(9600); d != 35*11*time.Second/96000 {
+		t.Errorf("silence at 9600 baud is %s", d)
+	}
+	for _, baud := range []int{115200, 0, -1} {
+		if d := ModbusSilence(baud); d != 1750*time.Microsecond {
+			t.Errorf("silence at %d baud is %s", baud, d)
+		}
+	}
+	if _, err := NewModbusMaster(&modbusFakePort{}, 0); err == nil {
+		t.Error("expected error for master with baud rate 0")
+	}
+	if _, err := NewModbusSlave(&modbusFakePort{}, 0, 1, newModbusTestHandler()); err == nil {
+		t.Error("expected error for slave with baud rate 0")
+	}
+}
+
+func TestModbusPackBits(t *testing.T) {
+	values := []bool{true, false, true, true, false, false, true, true, true, false}
+	data := modbusPackBits(values)
+	if !bytes.Equal(data, []byte{0xCD, 0x01}) {
+		t.Errorf("packed % X", data)
+	}
+	if unpacked := modbusUnpackBits(data, len(values)); !reflect.DeepEqual(unpacked, values) {
+		t.Errorf("unpacked %v", unpacked)
+	}
+	registers := []uint16{0x1234, 0xABCD}
+	if unpacked := modbusUnpackRegisters(modbusPackRegisters(registers)); !reflect.DeepEqual(unpacked, registers) {
+		t.Errorf("unpacked registers %v", unpacked)
+	}
+}
+
+// modbusFakePort returns the frames of responses to reads
+// and records all writes.
+type modbusFakePort struct {
+	written   bytes.Buffer
+	responses bytes.Buffer
+}
+
+func (port *modbusFakePort) Read(p []byte) (int, error) {
+	return port.responses.Read(p)
+}
+
+func (port *modbusFakePort) Write(p []byte) (int, error) {
+	return port.written.Write(p)
+}
+
+var errModbusFakePortEmpty = errors.New("no more frames")
+
+// modbusFakeBus returns one frame after the other
+// with a read timeout between the frames like a silent bus.
+// If chunk is not zero, a read returns at most chunk bytes.
+type modbusFakeBus struct {
+	modbusFakePort
+	frames   [][]byte
+	chunk    int
+	silence  bool
+	timeouts []time.Duration
+}
+
+func (bus *modbusFakeBus) SetReadTimeout(timeout time.Duration) error {
+	bus.timeouts = append(bus.timeouts, timeout)
+	return nil
+}
+
+func (bus *modbusFakeBus) Read(p []byte) (int, error) {
+	if bus.silence {
+		bus.silence = false
+		return 0, os.ErrDeadlineExceeded
+	}
+	if len(bus.frames) == 0 {
+		return 0, errModbusFakePortEmpty
+	}
+	if bus.chunk > 0 && len(p) > bus.chunk {
+		p = p[:bus.chunk]
+	}
+	n := copy(p, bus.frames[0])
+	bus.frames[0] = bus.frames[0][n:]
+	if len(bus.frames[0]) == 0 {
+		bus.frames = bus.frames[1:]
+		bus.silence = true
+	}
+	return n, nil
+}
+
+func modbusFrame(pdu ...byte) []byte {
+	crc := ModbusCRC16(pdu)
+	return append(pdu, byte(crc), byte(crc>>8))
+}
+
+type modbusTestHandler struct {
+	coils     []bool
+	registers []uint16
+}
+
+func newModbusTestHandler() *modbusTestHandler {
+	return &modbusTestHandler{coils: make([]bool, 32), registers: make([]uint16, 32)}
+}
+
+func (h *modbusTestHandler) ReadCoils(address, quantity uint16) ([]bool, error) {
+	if int(address)+int(quantity) > len(h.coils) {
+		return nil, MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS
+	}
+	return h.coils[address : address+quantity], nil
+}
+
+func (h *modbusTestHandler) ReadDiscreteInputs(address, quantity uint16) ([]bool, error) {
+	return h.ReadCoils(address, quantity)
+}
+
+func (h *modbusTestHandler) ReadHoldingRegisters(address, quantity uint16) ([]uint16, error) {
+	if int(address)+int(quantity) > len(h.registers) {
+		return nil, MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS
+	}
+	return h.registers[address : address+quantity], nil
+}
+
+func (h *modbusTestHandler) ReadInputRegisters(address, quantity uint16) ([]uint16, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (h *modbusTestHandler) WriteCoils(address uint16, values []bool) error {
+	if int(address)+len(values) > len(h.coils) {
+		return MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS
+	}
+	copy(h.coils[address:], values)
+	return nil
+}
+
+func (h *modbusTestHandler) WriteHoldingRegisters(address uint16, values []uint16) error {
+	if int(address)+len(values) > len(h.registers) {
+		return MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS
+	}
+	copy(h.registers[address:], values)
+	return nil
+}
+
+func TestModbusSlaveHandle(t *testing.T) {
+	handler := newModbusTestHandler()
+	handler.registers[1] = 0x1234
+	slave, err := NewModbusSlave(&modbusFakePort{}, 9600, 1, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		request  []byte
+		response []byte
+	}{
+		{"read registers", []byte{3, 0, 1, 0, 1}, []byte{3, 2, 0x12, 0x34}},
+		{"read registers out of range", []byte{3, 0, 31, 0, 2}, []byte{0x83, 2}},
+		{"read zero registers", []byte{3, 0, 0, 0, 0}, []byte{0x83, 3}},
+		{"read input registers failure", []byte{4, 0, 0, 0, 1}, []byte{0x84, 4}},
+		{"write single coil", []byte{5, 0, 2, 0xFF, 0}, []byte{5, 0, 2, 0xFF, 0}},
+		{"write single coil invalid", []byte{5, 0, 2, 0x12, 0}, []byte{0x85, 3}},
+		{"read coils", []byte{1, 0, 0, 0, 4}, []byte{1, 1, 0x04}},
+		{"write single register", []byte{6, 0, 3, 0xAB, 0xCD}, []byte{6, 0, 3, 0xAB, 0xCD}},
+		{"write coils", []byte{15, 0, 8, 0, 10, 2, 0xCD, 0x01}, []byte{15, 0, 8, 0, 10}},
+		{"write registers", []byte{16, 0, 4, 0, 2, 4, 0, 1, 0, 2}, []byte{16, 0, 4, 0, 2}},
+		{"read write registers", []byte{23, 0, 4, 0, 1, 0, 4, 0, 1, 2, 0x55, 0xAA}, []byte{23, 2, 0x55, 0xAA}},
+		{"unknown function", []byte{8, 0, 0, 0, 0}, []byte{0x88, 1}},
+		{"short unknown function", []byte{0x2B}, []byte{0xAB, 1}},
+		{"short read", []byte{3, 0, 1}, []byte{0x83, 3}},
+		// Frames that crashed the slave
+		{"short write coils", []byte{15, 0, 0, 0}, []byte{0x8F, 3}},
+		{"write coils without data", []byte{15, 0, 0, 0, 0xDB}, []byte{0x8F, 3}},
+		{"write coils with short data", []byte{15, 0, 0, 0, 0xDB, 28, 0}, []byte{0x8F, 3}},
+		{"write registers without data", []byte{16, 0, 0, 0, 1, 2}, []byte{0x90, 3}},
+		{"write registers byte count mismatch", []byte{16, 0, 0, 0, 1, 2, 0}, []byte{0x90, 3}},
+		{"write registers quantity mismatch", []byte{16, 0, 0, 0, 2, 2, 0, 1}, []byte{0x90, 3}},
+		{"short read write registers", []byte{23, 0, 0, 0, 1, 0, 0, 0}, []byte{0x97, 3}},
+		{"read write registers without data", []byte{23, 0, 0, 0, 1, 0, 0, 0, 1, 2}, []byte{0x97, 3}},
+	}
+	for _, test := range tests {
+		var response []byte
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: panic %v", test.name, r)
+				}
+			}()
+			response = slave.handle(test.request)
+		}()
+		if !bytes.Equal(response, test.response) {
+			t.Errorf("%s: response % X instead of % X", test.name, response, test.response)
+		}
+	}
+	if !handler.coils[2] || handler.registers[3] != 0xABCD || handler.registers[4] != 0x55AA || handler.registers[5] != 2 {
+		t.Errorf("handler state %v %v", handler.coils, handler.registers)
+	}
+}
+
+func TestModbusSlaveServeRequest(t *testing.T) {
+	bus := &modbusFakeBus{frames: [][]byte{
+		{0xFF},                            // noise
+		modbusFrame(2, 3, 0, 0, 0, 1),     // request to another slave
+		modbusFrame(1, 15, 0, 0, 0),       // short frame
+		modbusFrame(1, 16, 0, 0, 0, 1, 2), // byte count without data
+		modbusFrame(1, 6, 0, 1, 0, 7),
+	}}
+	slave, err := NewModbusSlave(bus, 115200, 1, newModbusTestHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		err = slave.ServeRequest()
+		if err == errModbusFakePortEmpty {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Frames with a valid CRC but invalid requests get exception responses
+	var expected []byte
+	expected = append(expected, modbusFrame(1, 0x8F, 3)...)
+	expected = append(expected, modbusFrame(1, 0x90, 3)...)
+	expected = append(expected, modbusFrame(1, 6, 0, 1, 0, 7)...)
+	if !bytes.Equal(bus.written.Bytes(), expected) {
+		t.Errorf("slave wrote % X instead of % X", bus.written.Bytes(), expected)
+	}
+}
+
+func TestModbusSlaveSilenceFraming(t *testing.T) {
+	noisy := append([]byte{0xFF}, modbusFrame(1, 6, 0, 2, 0, 8)...)
+	corrupted := modbusFrame(1, 6, 0, 3, 0, 9)
+	corrupted[4] ^= 0x01
+	tooLong := append(modbusFrame(1, 6, 0, 4, 0, 10), make([]byte, 300)...)
+	bus := &modbusFakeBus{
+		frames: [][]byte{
+			noisy,
+			corrupted,
+			tooLong,
+			modbusFrame(0, 6, 0, 5, 0, 11), // broadcast
+			modbusFrame(1, 16, 0, 6, 0, 2, 4, 0xAB, 0xCD, 0x12, 0x34),
+			modbusFrame(1, 3, 0, 5, 0, 3),
+		},
+		chunk: 3, // Frames arrive in multiple reads
+	}
+	handler := newModbusTestHandler()
+	slave, err := NewModbusSlave(bus, 9600, 1, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		err = slave.ServeRequest()
+		if err == errModbusFakePortEmpty {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for address := 2; address <= 4; address++ {
+		if handler.registers[address] != 0 {
+			t.Errorf("register %d written by an invalid frame", address)
+		}
+	}
+	if handler.registers[5] != 11 || handler.registers[6] != 0xABCD || handler.registers[7] != 0x1234 {
+		t.Errorf("registers %v", handler.registers[:8])
+	}
+	var expected []byte
+	expected = append(expected, modbusFrame(1, 16, 0, 6, 0, 2)...)
+	expected = append(expected, modbusFrame(1, 3, 6, 0, 11, 0xAB, 0xCD, 0x12, 0x34)...)
+	if !bytes.Equal(bus.written.Bytes(), expected) {
+		t.Errorf("slave wrote % X instead of % X", bus.written.Bytes(), expected)
+	}
+
+	// No timeout until the first byte, then the silent interval
+	silence := ModbusSilence(9600)
+	for i, timeout := range bus.timeouts {
+		if (i%2 == 0 && timeout != 0) || (i%2 == 1 && timeout != silence) {
+			t.Fatalf("read timeouts %v", bus.timeouts)
+		}
+	}
+}
+
+func TestModbusSlaveEOF(t *testing.T) {
+	// Without SetReadTimeout, read timeouts and io.EOF end frames
+	port := &modbusFakePort{}
+	port.responses.Write(modbusFrame(1, 6, 0, 1, 0, 7))
+	slave, err := NewModbusSlave(port, 9600, 1, newModbusTestHandler())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = slave.ServeRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(port.written.Bytes(), modbusFrame(1, 6, 0, 1, 0, 7)) {
+		t.Errorf("slave wrote % X", port.written.Bytes())
+	}
+	if err = slave.ServeRequest(); err != io.EOF {
+		t.Errorf("ServeRequest at the end returned %v instead of io.EOF", err)
+	}
+}
+
+func TestModbusMaster(t *testing.T) {
+	port := &modbusFakePort{}
+	master, err := NewModbusMaster(port, 115200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	port.responses.Write(modbusFrame(1, 3, 4, 0x12, 0x34, 0x56, 0x78))
+	values, err := master.ReadHoldingRegisters(1, 0x10, 2)
+	if err != nil || !reflect.DeepEqual(values, []uint16{0x1234, 0x5678}) {
+		t.Errorf("ReadHoldingRegisters returned %v, %v", values, err)
+	}
+	if !bytes.Equal(port.written.Bytes(), modbusFrame(1, 3, 0, 0x10, 0, 2)) {
+		t.Errorf("master wrote % X", port.written.Bytes())
+	}
+
+	port.responses.Write(modbusFrame(1, 0x83, 2))
+	_, err = master.ReadHoldingRegisters(1, 0x10, 2)
+	if err != MODBUS_EXCEPTION_ILLEGAL_DATA_ADDRESS {
+		t.Errorf("exception response returned %v", err)
+	}
+
+	bad := modbusFrame(1, 3, 2, 0, 0)
+	bad[len(bad)-1]++
+	port.responses.Write(bad)
+	_, err = master.ReadHoldingRegisters(1, 0, 1)
+	if err != ErrModbusCRC {
+		t.Errorf("bad CRC returned %v", err)
+	}
+
+	_, err = master.ReadHoldingRegisters(1, 0, 1)
+	if err != ErrModbusTimeout {
+		t.Errorf("missing response returned %v", err)
+	}
+
+	port.responses.Write(modbusFrame(1, 6, 0, 1, 0, 7))
+	err = master.WriteSingleRegister(1, 1, 7)
+	if err != nil {
+		t.Errorf("WriteSingleRegister: %s", err)
+	}
+	port.responses.Write(modbusFrame(1, 6, 0, 1, 0, 8))
+	err = master.WriteSingleRegister(1, 1, 7)
+	if !errors.Is(err, ErrModbusResponse) {
+		t.Errorf("wrong echo of single register returned %v", err)
+	}
+	port.responses.Write(modbusFrame(1, 15, 0, 0, 0, 9))
+	err = master.WriteMultipleCoils(1, 0, make([]bool, 10))
+	if !errors.Is(err, ErrModbusResponse) {
+		t.Errorf("wrong echo of coil quantity returned %v", err)
+	}
+
+	_, err = master.ReadCoils(0, 0, 1)
+	if err != ErrModbusBroadcastRead {
+		t.Errorf("broadcast read returned %v", err)
+	}
+	_, err = master.ReadWriteMultipleRegisters(0, 0, 1, 0, []uint16{1})
+	if err != ErrModbusBroadcastRead {
+		t.Errorf("broadcast read/write returned %v", err)
+	}
+	err = master.WriteSingleCoil(0, 0, true)
+	if err != nil {
+		t.Errorf("broadcast write: %s", err)
+	}
+}
+
+func TestModbusOverPTY(t *testing.T) {
+	master, uart := openPTYUART(t)
+	handler := newModbusTestHandler()
+	slave, err := NewModbusSlave(uart, 9600, 1, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go slave.Serve()
+
+	client, err := NewModbusMaster(master, 9600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint16(0); i < 3; i++ {
+		err = client.WriteSingleRegister(1, i, 100+i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := client.ReadHoldingRegisters(1, 0, i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values[i] != 100+i {
+			t.Errorf("read registers %v", values)
+		}
+	}
+}
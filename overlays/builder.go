@@ -11,6 +11,7 @@ func Compile() {
 	// // UART Overlayss
 	exec.Command("dtc", "-O", "dtb", "-o", "overlays/ADAFRUIT-UART1-00A0.dtbo", "-b", "o", "-@", "overlays/ADAFRUIT-UART1-00A0.dts").Run()
 	exec.Command("dtc", "-O", "dtb", "-o", "overlays/ADAFRUIT-UART2-00A0.dtbo", "-b", "o", "-@", "overlays/ADAFRUIT-UART2-00A0.dts").Run()
+	exec.Command("dtc", "-O", "dtb", "-o", "overlays/ADAFRUIT-UART3-00A0.dtbo", "-b", "o", "-@", "overlays/ADAFRUIT-UART3-00A0.dts").Run()
 	exec.Command("dtc", "-O", "dtb", "-o", "overlays/ADAFRUIT-UART4-00A0.dtbo", "-b", "o", "-@", "overlays/ADAFRUIT-UART4-00A0.dts").Run()
 	exec.Command("dtc", "-O", "dtb", "-o", "overlays/ADAFRUIT-UART5-00A0.dtbo", "-b", "o", "-@", "overlays/ADAFRUIT-UART5-00A0.dts").Run()
 }
@@ -22,6 +23,7 @@ func Copy() {
 	// UART Overlays
 	exec.Command("mv", "-f", "overlays/ADAFRUIT-UART1-00A0.dtbo", "/lib/firmware/ADAFRUIT-UART1-00A0.dtbo")
 	exec.Command("mv", "-f", "overlays/ADAFRUIT-UART2-00A0.dtbo", "/lib/firmware/ADAFRUIT-UART2-00A0.dtbo")
+	exec.Command("mv", "-f", "overlays/ADAFRUIT-UART3-00A0.dtbo", "/lib/firmware/ADAFRUIT-UART3-00A0.dtbo")
 	exec.Command("mv", "-f", "overlays/ADAFRUIT-UART4-00A0.dtbo", "/lib/firmware/ADAFRUIT-UART4-00A0.dtbo")
 	exec.Command("mv", "-f", "overlays/ADAFRUIT-UART5-00A0.dtbo", "/lib/firmware/ADAFRUIT-UART5-00A0.dtbo")
 }
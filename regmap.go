@@ -0,0 +1,324 @@
+package bbio
+
+import (
+	"fmt"
+	"sync"
+)
+
+// RegMapBus reads and writes consecutive register bytes of a device.
+// NewI2CRegMapBus and NewSPIRegMapBus implement it for I2C and SPI.
+type RegMapBus interface {
+	ReadRegisters(address uint16, data []byte) error
+	WriteRegisters(address uint16, data []byte) error
+}
+
+// I2CTxer is implemented by I2C and I2CDevice.
+type I2CTxer interface {
+	Tx(w, r []byte) error
+}
+
+type i2cRegMapBus struct {
+	i2c          I2CTxer
+	addressBytes int
+}
+
+// NewI2CRegMapBus returns a RegMapBus for a device with register addresses
+// of addressBytes (1 or 2) bytes, sent big endian before the data.
+// Reads are combined write-then-read transactions.
+func NewI2CRegMapBus(i2c I2CTxer, addressBytes int) (RegMapBus, error) {
+	if addressBytes != 1 && addressBytes != 2 {
+		return nil, fmt.Errorf("Invalid register address size %d", addressBytes)
+	}
+	return &i2cRegMapBus{i2c, addressBytes}, nil
+}
+
+func (bus *i2cRegMapBus) address(address uint16) []byte {
+	if bus.addressBytes == 2 {
+		return []byte{byte(address >> 8), byte(address)}
+	}
+	return []byte{byte(address)}
+}
+
+func (bus *i2cRegMapBus) ReadRegisters(address uint16, data []byte) error {
+	return bus.i2c.Tx(bus.address(address), data)
+}
+
+func (bus *i2cRegMapBus) WriteRegisters(address uint16, data []byte) error {
+	return bus.i2c.Tx(append(bus.address(address), data...), nil)
+}
+
+// SPIRegMapConfig describes how a SPI device marks the
+// register address byte for reads, writes and bursts.
+// Most devices set bit 7 for reads, so ReadFlag is 0x80.
+type SPIRegMapConfig struct {
+	ReadFlag  uint8
+	WriteFlag uint8
+	// BurstFlag is set for transfers of more than one byte,
+	// for example 0x40 for the ADXL345
+	BurstFlag uint8
+}
+
+type spiRegMapBus struct {
+	spi    *SPI
+	config SPIRegMapConfig
+}
+
+// NewSPIRegMapBus returns a RegMapBus for a device with 8 bit register
+// addresses, which are sent with the flags of config before the data
+// while chip select is held active.
+func NewSPIRegMapBus(spi *SPI, config SPIRegMapConfig) RegMapBus {
+	return &spiRegMapBus{spi, config}
+}
+
+func (bus *spiRegMapBus) transfer(address uint16, flag uint8, data []byte) ([]byte, error) {
+	if address > 0xFF {
+		return nil, fmt.Errorf("SPI register address 0x%X exceeds 8 bits", address)
+	}
+	if len(data) > 1 {
+		flag |= bus.config.BurstFlag
+	}
+	tx := make([]byte, 1+len(data))
+	tx[0] = byte(address) | flag
+	copy(tx[1:], data)
+	return bus.spi.Xfer2(tx, 0)
+}
+
+func (bus *spiRegMapBus) ReadRegisters(address uint16, data []byte) error {
+	rx, err := bus.transfer(address, bus.config.ReadFlag, make([]byte, len(data)))
+	if err != nil {
+		return err
+	}
+	copy(data, rx[1:])
+	return nil
+}
+
+func (bus *spiRegMapBus) WriteRegisters(address uint16, data []byte) error {
+	_, err := bus.transfer(address, bus.config.WriteFlag, data)
+	return err
+}
+
+// RegAccess is the access mode of a Register
+type RegAccess int
+
+const (
+	REG_READ_WRITE RegAccess = 0
+	REG_READ_ONLY  RegAccess = 1
+	// Write-only registers are cached by RegMap
+	// so that they can be read and updated.
+	REG_WRITE_ONLY RegAccess = 2
+)
+
+// Register is the declaration of a device register of 1 to 4 bytes.
+type Register struct {
+	Name    string
+	Address uint16
+	// Width in bytes from 1 to 4, zero means 1
+	Width        int
+	LittleEndian bool
+	Access       RegAccess
+	// Reset is the power on value, used as cached value
+	// of a write-only register until it is written
+	Reset uint32
+}
+
+// check returns an error for an invalid Width.
+func (reg *Register) check() error {
+	if reg.Width < 0 || reg.Width > 4 {
+		return fmt.Errorf("Register %s has invalid width %d", reg.Name, reg.Width)
+	}
+	return nil
+}
+
+func (reg *Register) width() int {
+	if reg.Width == 0 {
+		return 1
+	}
+	return reg.Width
+}
+
+func (reg *Register) decode(data []byte) uint32 {
+	var value uint32
+	for i := range data {
+		b := data[i]
+		if reg.LittleEndian {
+			b = data[len(data)-1-i]
+		}
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+func (reg *Register) encode(value uint32) []byte {
+	data := make([]byte, reg.width())
+	for i := range data {
+		b := byte(value >> uint(8*i))
+		if reg.LittleEndian {
+			data[i] = b
+		} else {
+			data[len(data)-1-i] = b
+		}
+	}
+	return data
+}
+
+// RegField is a bitfield of a Register.
+type RegField struct {
+	Name     string
+	Register *Register
+	Shift    uint
+	Bits     uint
+}
+
+// Mask returns the bits of the field within the register.
+func (field *RegField) Mask() uint32 {
+	return (1<<field.Bits - 1) << field.Shift
+}
+
+// RegMap reads and writes the declared registers of a device.
+// It is safe for concurrent use and Update is atomic
+// if the device is only accessed through the RegMap.
+type RegMap struct {
+	bus   RegMapBus
+	mutex sync.Mutex
+	cache map[*Register]uint32
+}
+
+func NewRegMap(bus RegMapBus) *RegMap {
+	return &RegMap{bus: bus, cache: make(map[*Register]uint32)}
+}
+
+func (m *RegMap) read(reg *Register) (uint32, error) {
+	if err := reg.check(); err != nil {
+		return 0, err
+	}
+	if reg.Access == REG_WRITE_ONLY {
+		if value, ok := m.cache[reg]; ok {
+			return value, nil
+		}
+		return reg.Reset, nil
+	}
+	data := make([]byte, reg.width())
+	err := m.bus.ReadRegisters(reg.Address, data)
+	if err != nil {
+		return 0, fmt.Errorf("Reading register %s: %w", reg.Name, err)
+	}
+	return reg.decode(data), nil
+}
+
+func (m *RegMap) write(reg *Register, value uint32) error {
+	if err := reg.check(); err != nil {
+		return err
+	}
+	if reg.Access == REG_READ_ONLY {
+		return fmt.Errorf("Register %s is read-only", reg.Name)
+	}
+	err := m.bus.WriteRegisters(reg.Address, reg.encode(value))
+	if err != nil {
+		return fmt.Errorf("Writing register %s: %w", reg.Name, err)
+	}
+	if reg.Access == REG_WRITE_ONLY {
+		m.cache[reg] = value
+	}
+	return nil
+}
+
+// Read returns the value of reg.
+// For write-only registers the last written value is returned.
+func (m *RegMap) Read(reg *Register) (uint32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.read(reg)
+}
+
+// ReadSigned returns the value of reg as two's complement
+// number with the width of the register.
+func (m *RegMap) ReadSigned(reg *Register) (int32, error) {
+	value, err := m.Read(reg)
+	if err != nil {
+		return 0, err
+	}
+	shift := uint(32 - 8*reg.width())
+	return int32(value<<shift) >> shift, nil
+}
+
+// Write sets reg to value.
+func (m *RegMap) Write(reg *Register, value uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.write(reg, value)
+}
+
+// Update sets the bits of mask in reg to the bits of value
+// with a read-modify-write.
+// The register is not written if its value doesn't change.
+func (m *RegMap) Update(reg *Register, mask, value uint32) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	old, err := m.read(reg)
+	if err != nil {
+		return err
+	}
+	updated := old&^mask | value&mask
+	if updated == old && (reg.Access != REG_WRITE_ONLY || m.cacheHas(reg)) {
+		return nil
+	}
+	return m.write(reg, updated)
+}
+
+func (m *RegMap) cacheHas(reg *Register) bool {
+	_, ok := m.cache[reg]
+	return ok
+}
+
+// ReadField returns the value of field shifted to bit 0.
+func (m *RegMap) ReadField(field *RegField) (uint32, error) {
+	value, err := m.Read(field.Register)
+	if err != nil {
+		return 0, err
+	}
+	return value & field.Mask() >> field.Shift, nil
+}
+
+// WriteField sets field to value with a read-modify-write of its register.
+func (m *RegMap) WriteField(field *RegField, value uint32) error {
+	if value > field.Mask()>>field.Shift {
+		return fmt.Errorf("Value %d too large for %d bit field %s", value, field.Bits, field.Name)
+	}
+	return m.Update(field.Register, field.Mask(), value<<field.Shift)
+}
+
+// ReadBurst reads registers that follow each other without gaps
+// in a single bus transaction, for example the three axes of a sensor,
+// so that all values belong to the same measurement.
+func (m *RegMap) ReadBurst(regs ...*Register) ([]uint32, error) {
+	if len(regs) == 0 {
+		return nil, nil
+	}
+	size := 0
+	for _, reg := range regs {
+		if err := reg.check(); err != nil {
+			return nil, err
+		}
+		if reg.Access == REG_WRITE_ONLY {
+			return nil, fmt.Errorf("Register %s is write-only", reg.Name)
+		}
+		if reg.Address != regs[0].Address+uint16(size) {
+			return nil, fmt.Errorf("Register %s does not follow the previous register", reg.Name)
+		}
+		size += reg.width()
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	data := make([]byte, size)
+	err := m.bus.ReadRegisters(regs[0].Address, data)
+	if err != nil {
+		return nil, fmt.Errorf("Reading registers from %s: %w", regs[0].Name, err)
+	}
+	values := make([]uint32, len(regs))
+	for i, reg := range regs {
+		values[i] = reg.decode(data[:reg.width()])
+		data = data[reg.width():]
+	}
+	return values, nil
+}
@@ -0,0 +1,100 @@
+package bbio
+
+import (
+	"bytes"
+	"testing"
+)
+
+// regMapFakeBus is a device with 256 register bytes.
+type regMapFakeBus struct {
+	regs   [256]byte
+	writes int
+}
+
+func (bus *regMapFakeBus) ReadRegisters(address uint16, data []byte) error {
+	copy(data, bus.regs[address:])
+	return nil
+}
+
+func (bus *regMapFakeBus) WriteRegisters(address uint16, data []byte) error {
+	copy(bus.regs[address:], data)
+	bus.writes++
+	return nil
+}
+
+func TestRegMap(t *testing.T) {
+	bus := &regMapFakeBus{}
+	m := NewRegMap(bus)
+	ctrl := &Register{Name: "CTRL", Address: 0x10}
+	big := &Register{Name: "BIG", Address: 0x20, Width: 2}
+	little := &Register{Name: "LITTLE", Address: 0x22, Width: 3, LittleEndian: true}
+	status := &Register{Name: "STATUS", Address: 0x30, Access: REG_READ_ONLY}
+	config := &Register{Name: "CONFIG", Address: 0x40, Access: REG_WRITE_ONLY, Reset: 0x0F}
+
+	if err := m.Write(big, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Write(little, 0xABCDEF); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bus.regs[0x20:0x25], []byte{0x12, 0x34, 0xEF, 0xCD, 0xAB}) {
+		t.Errorf("register bytes % X", bus.regs[0x20:0x25])
+	}
+	values, err := m.ReadBurst(big, little)
+	if err != nil || values[0] != 0x1234 || values[1] != 0xABCDEF {
+		t.Errorf("ReadBurst returned %X, %v", values, err)
+	}
+	if _, err = m.ReadBurst(big, status); err == nil {
+		t.Error("expected error for registers with gap")
+	}
+
+	bus.regs[0x20] = 0xFF
+	if value, err := m.ReadSigned(big); value != -204 || err != nil {
+		t.Errorf("ReadSigned returned %d, %v", value, err)
+	}
+
+	if m.Write(status, 1) == nil {
+		t.Error("expected error for writing read-only register")
+	}
+	if value, err := m.Read(config); value != 0x0F || err != nil {
+		t.Errorf("write-only register before write %X, %v", value, err)
+	}
+	field := &RegField{Name: "MODE", Register: config, Shift: 4, Bits: 2}
+	if err := m.WriteField(field, 2); err != nil {
+		t.Fatal(err)
+	}
+	if bus.regs[0x40] != 0x2F {
+		t.Errorf("write-only register is 0x%02X", bus.regs[0x40])
+	}
+	if value, err := m.ReadField(field); value != 2 || err != nil {
+		t.Errorf("ReadField returned %d, %v", value, err)
+	}
+	if m.WriteField(field, 4) == nil {
+		t.Error("expected error for too large field value")
+	}
+
+	writes := bus.writes
+	m.Update(ctrl, 0x01, 0x00)
+	if bus.writes != writes {
+		t.Error("unchanged register was written")
+	}
+}
+
+func TestRegMapInvalidWidth(t *testing.T) {
+	m := NewRegMap(&regMapFakeBus{})
+	for _, width := range []int{-1, 5} {
+		reg := &Register{Name: "INVALID", Width: width}
+		if _, err := m.Read(reg); err == nil {
+			t.Errorf("Read of register with width %d returned no error", width)
+		}
+		if err := m.Write(reg, 0); err == nil {
+			t.Errorf("Write of register with width %d returned no error", width)
+		}
+		if _, err := m.ReadSigned(reg); err == nil {
+			t.Errorf("ReadSigned of register with width %d returned no error", width)
+		}
+		if _, err := m.ReadBurst(reg); err == nil {
+			t.Errorf("ReadBurst of register with width %d returned no error", width)
+		}
+	}
+}
@@ -1,10 +1,21 @@
 package bbio
 
+// #include <asm/termbits.h>
+// #include <asm/ioctls.h>
+import "C"
+
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-
-	"github.com/huin/goserial"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
 )
 
 const (
@@ -18,85 +29,564 @@ const (
 type UARTNr int
 
 const (
+	UART0 UARTNr = 0
 	UART1 UARTNr = 1
 	UART2 UARTNr = 2
+	UART3 UARTNr = 3
 	UART4 UARTNr = 4
 	UART5 UARTNr = 5
 )
 
-type UARTParityMode goserial.ParityMode
+type UARTParityMode int
 
 const (
-	UART_PARITY_NONE = UARTParityMode(goserial.ParityNone)
-	UART_PARITY_EVEN = UARTParityMode(goserial.ParityEven)
-	UART_PARITY_ODD  = UARTParityMode(goserial.ParityOdd)
+	UART_PARITY_NONE UARTParityMode = 0
+	UART_PARITY_EVEN UARTParityMode = 1
+	UART_PARITY_ODD  UARTParityMode = 2
 )
 
-type UARTByteSize goserial.ByteSize
+type UARTByteSize int
 
 const (
-	UART_BYTESIZE_8 = UARTByteSize(goserial.Byte8)
-	UART_BYTESIZE_5 = UARTByteSize(goserial.Byte5)
-	UART_BYTESIZE_6 = UARTByteSize(goserial.Byte6)
-	UART_BYTESIZE_7 = UARTByteSize(goserial.Byte7)
+	UART_BYTESIZE_8 UARTByteSize = 8
+	UART_BYTESIZE_5 UARTByteSize = 5
+	UART_BYTESIZE_6 UARTByteSize = 6
+	UART_BYTESIZE_7 UARTByteSize = 7
 )
 
-type UARTStopBits goserial.StopBits
+type UARTStopBits int
 
 const (
-	UART_STOPBITS_1 = UARTStopBits(goserial.StopBits1)
-	UART_STOPBITS_2 = UARTStopBits(goserial.StopBits2)
+	UART_STOPBITS_1 UARTStopBits = 1
+	UART_STOPBITS_2 UARTStopBits = 2
 )
 
-// var uartTable = map[UARTName]uartInfo{
-// 	UART1: {"UART1", "/dev/ttyO1", "ADAFRUIT-UART1", "P9_26", "P9_24"},
-// 	UART2: {"UART2", "/dev/ttyO2", "ADAFRUIT-UART2", "P9_22", "P9_21"},
-// 	UART4: {"UART4", "/dev/ttyO4", "ADAFRUIT-UART4", "P9_11", "P9_13"},
-// 	UART5: {"UART5", "/dev/ttyO5", "ADAFRUIT-UART5", "P8_38", "P8_37"},
-// }
+// UARTInfo describes the device tree overlay and the pins of an UART.
+type UARTInfo struct {
+	Name       string
+	DeviceTree string // empty if the UART is always enabled
+	RXPin      string // empty if the UART is TX-only
+	TXPin      string
+	// BaseAddress of the UART registers,
+	// used to find the tty device of the UART
+	BaseAddress uint32
+}
+
+// TXOnly returns true if the receive pin of the UART
+// is not available on the headers.
+func (info *UARTInfo) TXOnly() bool {
+	return info.RXPin == ""
+}
+
+var uartTable = map[UARTNr]UARTInfo{
+	// UART0 is the serial console on the debug header J1
+	UART0: {"UART0", "", "J1_4", "J1_5", 0x44E09000},
+	UART1: {"UART1", "ADAFRUIT-UART1", "P9_26", "P9_24", 0x48022000},
+	UART2: {"UART2", "ADAFRUIT-UART2", "P9_22", "P9_21", 0x48024000},
+	UART3: {"UART3", "ADAFRUIT-UART3", "", "P9_42", 0x481A6000},
+	UART4: {"UART4", "ADAFRUIT-UART4", "P9_11", "P9_13", 0x481A8000},
+	UART5: {"UART5", "ADAFRUIT-UART5", "P8_38", "P8_37", 0x481AA000},
+}
+
+func UARTInfoByNr(nr UARTNr) (info UARTInfo, found bool) {
+	info, found = uartTable[nr]
+	return info, found
+}
+
+const ttyClassDir = "/sys/class/tty"
+
+// UARTDeviceName returns the tty device file of the UART nr.
+// The device is found by the register address of the UART in /sys/class/tty,
+// so it works with the omap-serial driver that names the devices /dev/ttyO%d
+// as well as the 8250 driver of newer kernels that uses /dev/ttyS%d.
+// Returns os.ErrNotExist if the UART is not enabled.
+func UARTDeviceName(nr UARTNr) (string, error) {
+	info, found := uartTable[nr]
+	if !found {
+		return "", fmt.Errorf("Invalid UART number %d", nr)
+	}
+
+	device := fmt.Sprintf("%x.serial", info.BaseAddress)
+	ttys, err := ioutil.ReadDir(ttyClassDir)
+	if err == nil {
+		for _, tty := range ttys {
+			link, err := filepath.EvalSymlinks(path.Join(ttyClassDir, tty.Name(), "device"))
+			if err == nil && path.Base(link) == device {
+				return "/dev/" + tty.Name(), nil
+			}
+		}
+	}
+
+	// Fall back to the naming conventions of the drivers
+	for _, format := range []string{"/dev/ttyO%d", "/dev/ttyS%d"} {
+		name := fmt.Sprintf(format, nr)
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
 
-// UART wraps "github.com/huin/goserial"
+// UART is a serial port configured via the termios2 ioctls of the kernel,
+// which allows arbitrary baud rates.
+// The file descriptor is non-blocking and managed by the Go runtime poller,
+// so reads and writes support deadlines and Close unblocks pending reads.
 type UART struct {
-	nr     UARTNr
-	serial io.ReadWriteCloser
+	nr         UARTNr
+	deviceTree string
+	txOnly     bool
+	file       *os.File
+	baud       int
+	frameBits  int
+	rs485      *UARTRS485Config
+
+	// Guards readTimeout and readDeadline,
+	// which can be set while another goroutine reads
+	deadlineMutex sync.Mutex
+	readTimeout   time.Duration
+	readDeadline  time.Time
 }
 
-func NewUART(nr UARTNr, baud int, size UARTByteSize, parity UARTParityMode, stopBits UARTStopBits) (*UART, error) {
-	dt := fmt.Sprintf("ADAFRUIT-UART%d", nr)
-	err := LoadDeviceTree(dt)
+// UARTOption configures an optional feature of an UART.
+type UARTOption func(uart *UART) error
+
+// NewUART opens the UART nr with the given configuration and options.
+// If the tty device of the UART does not exist,
+// the device tree overlay of the UART is loaded first.
+// UART3 is TX-only, its Read always returns an error.
+func NewUART(nr UARTNr, baud int, size UARTByteSize, parity UARTParityMode, stopBits UARTStopBits, options ...UARTOption) (*UART, error) {
+	info, found := uartTable[nr]
+	if !found {
+		return nil, fmt.Errorf("Invalid UART number %d", nr)
+	}
+
+	var dt string
+	name, err := UARTDeviceName(nr)
+	if err == os.ErrNotExist && info.DeviceTree != "" {
+		err = LoadDeviceTree(info.DeviceTree)
+		if err != nil {
+			return nil, err
+		}
+		dt = info.DeviceTree
+		name, err = UARTDeviceName(nr)
+	}
 	if err != nil {
+		if dt != "" {
+			UnloadDeviceTree(dt)
+		}
+		return nil, fmt.Errorf("Can't find tty device of %s: %s", info.Name, err)
+	}
+
+	uart, err := NewUARTDevice(name, baud, size, parity, stopBits, options...)
+	if err != nil {
+		if dt != "" {
+			UnloadDeviceTree(dt)
+		}
 		return nil, err
 	}
+	uart.nr = nr
+	uart.deviceTree = dt
+	uart.txOnly = info.TXOnly()
 
-	uart := &UART{nr: nr}
+	return uart, nil
+}
 
-	config := &goserial.Config{
-		Name:     fmt.Sprintf("/dev/ttyO%d", nr),
-		Baud:     baud,
-		Size:     goserial.ByteSize(size),
-		Parity:   goserial.ParityMode(parity),
-		StopBits: goserial.StopBits(stopBits),
+// NewUARTDevice opens the serial device name, for example /dev/ttyUSB0
+// or a pseudo terminal, and configures it in raw mode.
+// No device tree overlay is loaded.
+func NewUARTDevice(name string, baud int, size UARTByteSize, parity UARTParityMode, stopBits UARTStopBits, options ...UARTOption) (*UART, error) {
+	// Non-blocking so that we don't wait for carrier detect
+	// and os.NewFile registers the file with the runtime poller
+	fd, err := syscall.Open(name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
 	}
-	uart.serial, err = goserial.OpenPort(config)
+	uart := &UART{nr: -1, file: os.NewFile(uintptr(fd), name)}
+
+	err = uart.modifyTermios(func(t *C.struct_termios2) error {
+		// Raw mode like cfmakeraw
+		t.c_iflag &^= C.IGNBRK | C.BRKINT | C.PARMRK | C.ISTRIP | C.INLCR | C.IGNCR | C.ICRNL | C.IXON | C.IXOFF | C.IXANY
+		t.c_oflag &^= C.OPOST
+		t.c_lflag &^= C.ECHO | C.ECHONL | C.ICANON | C.ISIG | C.IEXTEN
+		t.c_cflag &^= C.CRTSCTS
+		t.c_cflag |= C.CREAD | C.CLOCAL
+		// Readable as soon as one byte is available,
+		// timeouts are implemented with deadlines
+		t.c_cc[C.VMIN] = 1
+		t.c_cc[C.VTIME] = 0
+		if err := setTermiosBaud(t, baud); err != nil {
+			return err
+		}
+		return setTermiosFrameFormat(t, size, parity, stopBits)
+	})
 	if err != nil {
+		uart.file.Close()
 		return nil, err
 	}
+	uart.baud = baud
+	uart.frameBits = uartFrameBits(size, parity, stopBits)
+
+	for _, option := range options {
+		err = option(uart)
+		if err != nil {
+			uart.file.Close()
+			return nil, err
+		}
+	}
 
 	return uart, nil
 }
 
+// ioctl uses the raw connection of the file, because
+// File.Fd would switch the file back to blocking mode.
+func (uart *UART) ioctl(request, arg uintptr) error {
+	conn, err := uart.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (uart *UART) ioctlPtr(request uintptr, arg unsafe.Pointer) error {
+	conn, err := uart.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (uart *UART) modifyTermios(modify func(*C.struct_termios2) error) error {
+	var t C.struct_termios2
+	err := uart.ioctlPtr(C.TCGETS2, unsafe.Pointer(&t))
+	if err != nil {
+		return err
+	}
+	err = modify(&t)
+	if err != nil {
+		return err
+	}
+	return uart.ioctlPtr(C.TCSETS2, unsafe.Pointer(&t))
+}
+
+func setTermiosBaud(t *C.struct_termios2, baud int) error {
+	if baud <= 0 {
+		return fmt.Errorf("Invalid UART baud rate %d", baud)
+	}
+	// BOTHER allows any baud rate, the input baud rate follows the output
+	t.c_cflag &^= C.CBAUD | C.CBAUD<<C.IBSHIFT
+	t.c_cflag |= C.BOTHER | C.BOTHER<<C.IBSHIFT
+	t.c_ispeed = C.speed_t(baud)
+	t.c_ospeed = C.speed_t(baud)
+	return nil
+}
+
+// uartFrameBits returns the number of bits
+// to transmit one byte including start, parity and stop bits.
+func uartFrameBits(size UARTByteSize, parity UARTParityMode, stopBits UARTStopBits) int {
+	bits := 1 + int(size) + int(stopBits)
+	if parity != UART_PARITY_NONE {
+		bits++
+	}
+	return bits
+}
+
+func setTermiosFrameFormat(t *C.struct_termios2, size UARTByteSize, parity UARTParityMode, stopBits UARTStopBits) error {
+	t.c_cflag &^= C.CSIZE
+	switch size {
+	case UART_BYTESIZE_5:
+		t.c_cflag |= C.CS5
+	case UART_BYTESIZE_6:
+		t.c_cflag |= C.CS6
+	case UART_BYTESIZE_7:
+		t.c_cflag |= C.CS7
+	case UART_BYTESIZE_8:
+		t.c_cflag |= C.CS8
+	default:
+		return fmt.Errorf("Invalid UART byte size %d", size)
+	}
+
+	t.c_cflag &^= C.PARENB | C.PARODD
+	t.c_iflag &^= C.INPCK
+	switch parity {
+	case UART_PARITY_NONE:
+	case UART_PARITY_EVEN:
+		t.c_cflag |= C.PARENB
+		t.c_iflag |= C.INPCK
+	case UART_PARITY_ODD:
+		t.c_cflag |= C.PARENB | C.PARODD
+		t.c_iflag |= C.INPCK
+	default:
+		return fmt.Errorf("Invalid UART parity mode %d", parity)
+	}
+
+	switch stopBits {
+	case UART_STOPBITS_1:
+		t.c_cflag &^= C.CSTOPB
+	case UART_STOPBITS_2:
+		t.c_cflag |= C.CSTOPB
+	default:
+		return fmt.Errorf("Invalid UART stop bits %d", stopBits)
+	}
+	return nil
+}
+
+// Nr returns the number of the UART or -1
+// if it was opened with NewUARTDevice.
+func (uart *UART) Nr() UARTNr {
+	return uart.nr
+}
+
+// TXOnly returns true if the UART can't receive,
+// like UART3 which has only a transmit pin.
+func (uart *UART) TXOnly() bool {
+	return uart.txOnly
+}
+
+// Name returns the device file name of the UART.
+func (uart *UART) Name() string {
+	return uart.file.Name()
+}
+
+func (uart *UART) Baud() int {
+	return uart.baud
+}
+
+// SetBaud changes the baud rate at runtime.
+// Any baud rate supported by the UART hardware can be used.
+func (uart *UART) SetBaud(baud int) error {
+	err := uart.modifyTermios(func(t *C.struct_termios2) error {
+		return setTermiosBaud(t, baud)
+	})
+	if err != nil {
+		return err
+	}
+	uart.baud = baud
+	return nil
+}
+
+// SetFrameFormat changes byte size, parity and stop bits at runtime.
+func (uart *UART) SetFrameFormat(size UARTByteSize, parity UARTParityMode, stopBits UARTStopBits) error {
+	err := uart.modifyTermios(func(t *C.struct_termios2) error {
+		return setTermiosFrameFormat(t, size, parity, stopBits)
+	})
+	if err != nil {
+		return err
+	}
+	uart.frameBits = uartFrameBits(size, parity, stopBits)
+	return nil
+}
+
+// ByteDuration returns the transmission time of one byte
+// with the current baud rate and frame format.
+func (uart *UART) ByteDuration() time.Duration {
+	return time.Duration(uart.frameBits) * time.Second / time.Duration(uart.baud)
+}
+
+// SetHardwareFlowControl enables or disables RTS/CTS flow control.
+func (uart *UART) SetHardwareFlowControl(enable bool) error {
+	return uart.modifyTermios(func(t *C.struct_termios2) error {
+		if enable {
+			t.c_cflag |= C.CRTSCTS
+		} else {
+			t.c_cflag &^= C.CRTSCTS
+		}
+		return nil
+	})
+}
+
+// SetReadTimeout sets the time every Read waits for data
+// before returning zero bytes and an error wrapping os.ErrDeadlineExceeded,
+// see IsTimeout.
+// An earlier deadline set with SetReadDeadline takes precedence.
+// Zero means that Read blocks until at least one byte is available.
+func (uart *UART) SetReadTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("Invalid UART read timeout %s", timeout)
+	}
+	uart.deadlineMutex.Lock()
+	defer uart.deadlineMutex.Unlock()
+	uart.readTimeout = timeout
+	return nil
+}
+
+// IsTimeout returns true if err was returned by a Read
+// because its read timeout or deadline expired.
+// Unlike io.EOF, reading can continue after a timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// SetDeadline sets the read and write deadlines like net.Conn.
+func (uart *UART) SetDeadline(t time.Time) error {
+	err := uart.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	return uart.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future Read calls
+// and any currently-blocked Read call like net.Conn.
+// After the deadline Read returns an error wrapping os.ErrDeadlineExceeded.
+// A zero value for t means Read will not time out.
+func (uart *UART) SetReadDeadline(t time.Time) error {
+	uart.deadlineMutex.Lock()
+	defer uart.deadlineMutex.Unlock()
+	err := uart.file.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	uart.readDeadline = t
+	return nil
+}
+
+// restoreReadDeadline sets the deadline of the file
+// back to the one set with SetReadDeadline.
+func (uart *UART) restoreReadDeadline() {
+	uart.deadlineMutex.Lock()
+	defer uart.deadlineMutex.Unlock()
+	uart.file.SetReadDeadline(uart.readDeadline)
+}
+
+// SetWriteDeadline sets the deadline for future Write calls
+// and any currently-blocked Write call like net.Conn.
+// A zero value for t means Write will not time out.
+func (uart *UART) SetWriteDeadline(t time.Time) error {
+	return uart.file.SetWriteDeadline(t)
+}
+
+// Read reads up to len(p) bytes. If no data arrived before the read timeout
+// set with SetReadTimeout or the read deadline, an error wrapping
+// os.ErrDeadlineExceeded is returned, see IsTimeout.
 func (uart *UART) Read(p []byte) (n int, err error) {
-	return uart.serial.Read(p)
+	return uart.ReadContext(context.Background(), p)
+}
+
+// ReadContext is like Read, but returns ctx.Err()
+// if ctx is done before data arrived.
+func (uart *UART) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	if uart.txOnly {
+		return 0, fmt.Errorf("UART%d is TX-only", uart.nr)
+	}
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	uart.deadlineMutex.Lock()
+	readDeadline := uart.readDeadline
+	deadline := readDeadline
+	if uart.readTimeout > 0 {
+		t := time.Now().Add(uart.readTimeout)
+		if deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	// The deadline of the file can expire before ctx is done
+	ctxDeadline := false
+	if t, ok := ctx.Deadline(); ok && (deadline.IsZero() || t.Before(deadline)) {
+		deadline = t
+		ctxDeadline = true
+	}
+	if !deadline.Equal(readDeadline) {
+		uart.file.SetReadDeadline(deadline)
+		defer uart.restoreReadDeadline()
+	}
+	uart.deadlineMutex.Unlock()
+
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				// Interrupt the pending read
+				uart.file.SetReadDeadline(time.Unix(1, 0))
+			case <-done:
+			}
+		}()
+		defer func() {
+			close(done)
+			wg.Wait()
+			if ctx.Err() != nil {
+				uart.restoreReadDeadline()
+			}
+		}()
+	}
+
+	n, err = uart.file.Read(p)
+	if err != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return n, ctxErr
+		}
+		if ctxDeadline {
+			return n, context.DeadlineExceeded
+		}
+	}
+	return n, err
 }
 
 func (uart *UART) Write(p []byte) (n int, err error) {
-	return uart.serial.Write(p)
+	if uart.rs485 != nil && uart.rs485.DriverEnable != nil {
+		return uart.writeRS485GPIO(p)
+	}
+	return uart.file.Write(p)
+}
+
+// Flush discards all data received but not read
+// and written but not transmitted.
+func (uart *UART) Flush() error {
+	return uart.ioctl(C.TCFLSH, C.TCIOFLUSH)
+}
+
+// Drain waits until all written data has been transmitted.
+func (uart *UART) Drain() error {
+	// TCSBRK with a non zero argument is tcdrain
+	return uart.ioctl(C.TCSBRK, 1)
+}
+
+// SendBreak transmits a continuous stream of zero bits for duration.
+func (uart *UART) SendBreak(duration time.Duration) error {
+	err := uart.ioctl(C.TIOCSBRK, 0)
+	if err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	return uart.ioctl(C.TIOCCBRK, 0)
 }
 
 func (uart *UART) Close() error {
-	err := uart.serial.Close()
+	err := uart.file.Close()
 	if err != nil {
 		return err
 	}
-	return UnloadDeviceTree(fmt.Sprintf("ADAFRUIT-UART%d", uart.nr))
+	if uart.deviceTree == "" {
+		return nil
+	}
+	return UnloadDeviceTree(uart.deviceTree)
 }
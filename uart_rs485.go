@@ -0,0 +1,160 @@
+package bbio
+
+// #include <linux/serial.h>
+// #include <asm/ioctls.h>
+import "C"
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// UARTRS485Config configures RS-485 half-duplex mode
+// where the bus driver is only enabled while sending.
+type UARTRS485Config struct {
+	// DelayBeforeSend is the time between enabling the driver
+	// and sending the first byte. Millisecond resolution for the kernel mode.
+	DelayBeforeSend time.Duration
+	// DelayAfterSend is the time between the end of the last byte
+	// and disabling the driver. Millisecond resolution for the kernel mode.
+	DelayAfterSend time.Duration
+	// ActiveLow inverts the driver enable signal,
+	// so it is low while sending.
+	ActiveLow bool
+	// RxDuringTx keeps the receiver enabled while sending.
+	// Only supported by the kernel mode.
+	RxDuringTx bool
+	// DriverEnable is an optional GPIO that will be toggled around writes
+	// in software instead of using the RTS line of the UART controlled
+	// by the kernel driver. The GPIO will be configured as output.
+	DriverEnable *GPIO
+}
+
+// UARTWithRS485 is an UARTOption for NewUART that enables RS-485 mode,
+// see UART.SetRS485.
+func UARTWithRS485(config UARTRS485Config) UARTOption {
+	return func(uart *UART) error {
+		return uart.SetRS485(&config)
+	}
+}
+
+// RS485 returns the RS-485 configuration or nil if not enabled.
+func (uart *UART) RS485() *UARTRS485Config {
+	return uart.rs485
+}
+
+// SetRS485 enables RS-485 half-duplex mode, or disables it if config is nil.
+// Without config.DriverEnable the kernel driver is configured
+// with the TIOCSRS485 ioctl to drive the RTS line while sending.
+func (uart *UART) SetRS485(config *UARTRS485Config) error {
+	if uart.rs485 != nil && uart.rs485.DriverEnable == nil {
+		err := uart.setKernelRS485(nil)
+		if err != nil {
+			return err
+		}
+	}
+	uart.rs485 = nil
+	if config == nil {
+		return nil
+	}
+
+	if config.DriverEnable == nil {
+		err := uart.setKernelRS485(config)
+		if err != nil {
+			return fmt.Errorf("UART %s does not support kernel RS-485 mode, use a DriverEnable GPIO: %s", uart.Name(), err)
+		}
+	} else {
+		err := config.DriverEnable.SetDirection(GPIO_OUTPUT)
+		if err != nil {
+			return err
+		}
+		err = config.DriverEnable.SetValue(config.ActiveLow)
+		if err != nil {
+			return err
+		}
+	}
+	uart.rs485 = config
+	return nil
+}
+
+// uartSerialRS485 is struct serial_rs485 of linux/serial.h.
+type uartSerialRS485 struct {
+	flags              uint32
+	delayRTSBeforeSend uint32
+	delayRTSAfterSend  uint32
+	padding            [5]uint32
+}
+
+// Flags of uartSerialRS485
+const (
+	uartRS485Enabled      = 1 << 0
+	uartRS485RTSOnSend    = 1 << 1
+	uartRS485RTSAfterSend = 1 << 2
+	uartRS485RxDuringTx   = 1 << 4
+)
+
+// The size and flags must match the kernel headers
+var (
+	_ [unsafe.Sizeof(C.struct_serial_rs485{})]byte                         = [unsafe.Sizeof(uartSerialRS485{})]byte{}
+	_ [unsafe.Offsetof(C.struct_serial_rs485{}.delay_rts_before_send)]byte = [unsafe.Offsetof(uartSerialRS485{}.delayRTSBeforeSend)]byte{}
+	_ [unsafe.Offsetof(C.struct_serial_rs485{}.delay_rts_after_send)]byte  = [unsafe.Offsetof(uartSerialRS485{}.delayRTSAfterSend)]byte{}
+	_ [C.SER_RS485_ENABLED]byte                                            = [uartRS485Enabled]byte{}
+	_ [C.SER_RS485_RTS_ON_SEND]byte                                        = [uartRS485RTSOnSend]byte{}
+	_ [C.SER_RS485_RTS_AFTER_SEND]byte                                     = [uartRS485RTSAfterSend]byte{}
+	_ [C.SER_RS485_RX_DURING_TX]byte                                       = [uartRS485RxDuringTx]byte{}
+)
+
+// newUARTSerialRS485 returns the kernel configuration for config,
+// nil disables RS-485 mode.
+func newUARTSerialRS485(config *UARTRS485Config) (rs485 uartSerialRS485) {
+	if config == nil {
+		return rs485
+	}
+	rs485.flags = uartRS485Enabled
+	if config.ActiveLow {
+		rs485.flags |= uartRS485RTSAfterSend
+	} else {
+		rs485.flags |= uartRS485RTSOnSend
+	}
+	if config.RxDuringTx {
+		rs485.flags |= uartRS485RxDuringTx
+	}
+	rs485.delayRTSBeforeSend = uint32(config.DelayBeforeSend / time.Millisecond)
+	rs485.delayRTSAfterSend = uint32(config.DelayAfterSend / time.Millisecond)
+	return rs485
+}
+
+func (uart *UART) setKernelRS485(config *UARTRS485Config) error {
+	rs485 := newUARTSerialRS485(config)
+	return uart.ioctlPtr(C.TIOCSRS485, unsafe.Pointer(&rs485))
+}
+
+// writeRS485GPIO enables the driver, writes p, waits until
+// the last byte has left the UART and disables the driver.
+func (uart *UART) writeRS485GPIO(p []byte) (n int, err error) {
+	config := uart.rs485
+	err = config.DriverEnable.SetValue(!config.ActiveLow)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		e := config.DriverEnable.SetValue(config.ActiveLow)
+		if err == nil {
+			err = e
+		}
+	}()
+
+	time.Sleep(config.DelayBeforeSend)
+	n, err = uart.file.Write(p)
+	if err != nil {
+		return n, err
+	}
+	err = uart.Drain()
+	if err != nil {
+		return n, err
+	}
+	// Drain may return while the last byte is still in the shift register
+	time.Sleep(uart.ByteDuration() + config.DelayAfterSend)
+	return n, nil
+}
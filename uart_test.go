@@ -0,0 +1,386 @@
+package bbio
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPTY opens a pseudo terminal pair and returns the master
+// and the name of the slave device. The test is skipped
+// if pseudo terminals are not available.
+func openPTY(t *testing.T) (master *os.File, slaveName string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("No pseudo terminals: %s", err)
+	}
+	var unlock int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	if errno != 0 {
+		master.Close()
+		t.Skipf("Can't unlock pseudo terminal: %s", errno)
+	}
+	var nr uint32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&nr)))
+	if errno != 0 {
+		master.Close()
+		t.Skipf("Can't get pseudo terminal number: %s", errno)
+	}
+	t.Cleanup(func() { master.Close() })
+	return master, fmt.Sprintf("/dev/pts/%d", nr)
+}
+
+func openPTYUART(t *testing.T) (master *os.File, uart *UART) {
+	t.Helper()
+	master, name := openPTY(t)
+	uart, err := NewUARTDevice(name, UART_BAUD_9600, UART_BYTESIZE_8, UART_PARITY_NONE, UART_STOPBITS_1)
+	if err != nil {
+		t.Skipf("Can't open pseudo terminal %s as UART: %s", name, err)
+	}
+	t.Cleanup(func() { uart.Close() })
+	return master, uart
+}
+
+func TestUARTRS485WithoutKernelSupport(t *testing.T) {
+	_, uart := openPTYUART(t)
+
+	// Pseudo terminals have no RS-485 mode
+	err := uart.SetRS485(&UARTRS485Config{DelayAfterSend: time.Millisecond})
+	if err == nil {
+		t.Skip("Pseudo terminal supports RS-485 mode")
+	}
+	if uart.RS485() != nil {
+		t.Error("RS-485 config set after error")
+	}
+	if err = uart.SetRS485(nil); err != nil {
+		t.Errorf("disabling RS-485 mode returned %s", err)
+	}
+	n, err := uart.Write([]byte("ok"))
+	if n != 2 || err != nil {
+		t.Errorf("Write after failed SetRS485 returned %d, %v", n, err)
+	}
+}
+
+// useTestGPIODir makes the GPIO functions use a temporary directory
+// with the direction and value files of GPIO nr.
+func useTestGPIODir(t *testing.T, nr int) string {
+	t.Helper()
+	sysfsDir := gpioSysfsDir
+	t.Cleanup(func() { gpioSysfsDir = sysfsDir })
+	gpioSysfsDir = t.TempDir()
+	dir := filepath.Join(gpioSysfsDir, fmt.Sprintf("gpio%d", nr))
+	err := os.Mkdir(dir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "direction"), []byte("in"), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "value"), []byte("1"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestUARTRS485DriverEnableGPIO(t *testing.T) {
+	for _, activeLow := range []bool{false, true} {
+		master, uart := openPTYUART(t)
+		dir := useTestGPIODir(t, 7)
+		checkFile := func(name, expected string) {
+			t.Helper()
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil || string(data) != expected {
+				t.Errorf("active low %t: %s is %q instead of %q, %v", activeLow, name, data, expected, err)
+			}
+		}
+		idle := "0"
+		if activeLow {
+			idle = "1"
+		}
+
+		gpio := &GPIO{nr: 7}
+		err := uart.SetRS485(&UARTRS485Config{DriverEnable: gpio, ActiveLow: activeLow})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gpio.value.Close()
+		checkFile("direction", "out")
+		checkFile("value", idle)
+
+		n, err := uart.Write([]byte("ok"))
+		if n != 2 || err != nil {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+		buf := make([]byte, 8)
+		n, err = master.Read(buf)
+		if err != nil || string(buf[:n]) != "ok" {
+			t.Errorf("master read %q, %v", buf[:n], err)
+		}
+		checkFile("value", idle)
+	}
+
+	// Without the direction file the GPIO can't be used
+	_, uart := openPTYUART(t)
+	useTestGPIODir(t, 7)
+	if uart.SetRS485(&UARTRS485Config{DriverEnable: &GPIO{nr: 8}}) == nil {
+		t.Error("expected error for missing GPIO")
+	}
+	if uart.RS485() != nil {
+		t.Error("RS-485 config set after error")
+	}
+}
+
+func TestUARTSerialRS485(t *testing.T) {
+	// struct serial_rs485 of linux/serial.h
+	var rs485 uartSerialRS485
+	if unsafe.Sizeof(rs485) != 32 ||
+		unsafe.Offsetof(rs485.flags) != 0 ||
+		unsafe.Offsetof(rs485.delayRTSBeforeSend) != 4 ||
+		unsafe.Offsetof(rs485.delayRTSAfterSend) != 8 {
+		t.Errorf("uartSerialRS485 has size %d and offsets %d, %d, %d", unsafe.Sizeof(rs485),
+			unsafe.Offsetof(rs485.flags), unsafe.Offsetof(rs485.delayRTSBeforeSend), unsafe.Offsetof(rs485.delayRTSAfterSend))
+	}
+
+	tests := []struct {
+		config   *UARTRS485Config
+		expected uartSerialRS485
+	}{
+		{nil, uartSerialRS485{}},
+		{&UARTRS485Config{}, uartSerialRS485{flags: 0x03}},
+		{
+			&UARTRS485Config{DelayBeforeSend: 2 * time.Millisecond, DelayAfterSend: 1500 * time.Microsecond},
+			uartSerialRS485{flags: 0x03, delayRTSBeforeSend: 2, delayRTSAfterSend: 1},
+		},
+		{&UARTRS485Config{ActiveLow: true}, uartSerialRS485{flags: 0x05}},
+		{&UARTRS485Config{RxDuringTx: true}, uartSerialRS485{flags: 0x13}},
+		{&UARTRS485Config{ActiveLow: true, RxDuringTx: true}, uartSerialRS485{flags: 0x15}},
+	}
+	for _, test := range tests {
+		if rs485 := newUARTSerialRS485(test.config); rs485 != test.expected {
+			t.Errorf("config %+v returned %+v instead of %+v", test.config, rs485, test.expected)
+		}
+	}
+}
+
+func TestUARTInfo(t *testing.T) {
+	for nr := UART0; nr <= UART5; nr++ {
+		info, found := UARTInfoByNr(nr)
+		if !found || info.Name != fmt.Sprintf("UART%d", nr) || info.TXPin == "" || info.BaseAddress == 0 {
+			t.Errorf("UART%d info %+v, %t", nr, info, found)
+		}
+		if info.TXOnly() != (nr == UART3) {
+			t.Errorf("UART%d TXOnly is %t", nr, info.TXOnly())
+		}
+		if (info.DeviceTree == "") != (nr == UART0) {
+			t.Errorf("UART%d device tree '%s'", nr, info.DeviceTree)
+		}
+	}
+	if _, found := UARTInfoByNr(6); found {
+		t.Error("found UART6")
+	}
+	if _, err := UARTDeviceName(6); err == nil {
+		t.Error("expected error for UART6")
+	}
+}
+
+func TestUARTTXOnlyRead(t *testing.T) {
+	_, uart := openPTYUART(t)
+	uart.nr, uart.txOnly = UART3, true
+	if _, err := uart.Read(make([]byte, 1)); err == nil {
+		t.Error("expected error for reading TX-only UART")
+	}
+	if _, err := uart.Write([]byte{1}); err != nil {
+		t.Errorf("Write to TX-only UART returned %s", err)
+	}
+}
+
+func TestUARTReadTimeout(t *testing.T) {
+	_, uart := openPTYUART(t)
+	err := uart.SetReadTimeout(20 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	n, err := uart.Read(make([]byte, 8))
+	if n != 0 || !IsTimeout(err) {
+		t.Fatalf("Read after timeout returned %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond || d > time.Second {
+		t.Errorf("read timeout took %s", d)
+	}
+
+	// Reading can continue after a timeout
+	n, err = uart.Read(make([]byte, 8))
+	if n != 0 || !IsTimeout(err) {
+		t.Fatalf("second Read after timeout returned %d, %v", n, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = uart.ReadContext(ctx, make([]byte, 8))
+	if err != context.DeadlineExceeded {
+		t.Errorf("ReadContext returned %v instead of context.DeadlineExceeded", err)
+	}
+}
+
+func TestUARTConcurrentReadDeadline(t *testing.T) {
+	_, uart := openPTYUART(t)
+	uart.SetReadTimeout(time.Millisecond)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			uart.Read(make([]byte, 1))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			uart.SetReadDeadline(time.Now().Add(time.Millisecond))
+		}
+	}()
+	wg.Wait()
+}
+
+func TestIsTimeout(t *testing.T) {
+	if IsTimeout(nil) || IsTimeout(os.ErrClosed) {
+		t.Error("IsTimeout true for non timeout errors")
+	}
+	if !IsTimeout(&os.PathError{Op: "read", Path: "/dev/ttyS1", Err: os.ErrDeadlineExceeded}) {
+		t.Error("IsTimeout false for deadline error")
+	}
+}
+
+func TestUARTReadWrite(t *testing.T) {
+	master, uart := openPTYUART(t)
+
+	n, err := uart.Write([]byte("hello"))
+	if n != 5 || err != nil {
+		t.Fatalf("Write returned %d, %v", n, err)
+	}
+	buf := make([]byte, 16)
+	n, err = master.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("master read %q, %v", buf[:n], err)
+	}
+
+	// Raw mode passes all bytes unchanged
+	data := []byte{0x00, 0x03, '\r', '\n', 0x11, 0x13, 0x7F, 0xFF}
+	_, err = master.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uart.SetReadTimeout(time.Second)
+	received := make([]byte, 0, len(data))
+	for len(received) < len(data) {
+		n, err = uart.Read(buf)
+		if err != nil {
+			t.Fatalf("Read after %q: %s", received, err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	if string(received) != string(data) {
+		t.Errorf("received % X instead of % X", received, data)
+	}
+}
+
+func TestUARTReadDeadline(t *testing.T) {
+	master, uart := openPTYUART(t)
+
+	err := uart.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = uart.Read(make([]byte, 1))
+	if !IsTimeout(err) {
+		t.Fatalf("Read after deadline returned %v", err)
+	}
+
+	// A deadline set while Read is blocked unblocks it
+	uart.SetReadDeadline(time.Time{})
+	done := make(chan error)
+	go func() {
+		_, err := uart.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	uart.SetReadDeadline(time.Now())
+	select {
+	case err = <-done:
+		if !IsTimeout(err) {
+			t.Errorf("blocked Read returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SetReadDeadline did not unblock Read")
+	}
+
+	// Without deadline data is read again
+	uart.SetReadDeadline(time.Time{})
+	master.Write([]byte{42})
+	buf := make([]byte, 1)
+	n, err := uart.Read(buf)
+	if n != 1 || buf[0] != 42 || err != nil {
+		t.Errorf("Read returned %d, %v, %v", n, buf, err)
+	}
+}
+
+// termios2 is struct termios2 of asm-generic/termbits.h,
+// cgo can't be used in tests.
+type termios2 struct {
+	iflag, oflag, cflag, lflag uint32
+	line                       uint8
+	cc                         [19]uint8
+	ispeed, ospeed             uint32
+}
+
+// tcgets2 is _IOR('T', 0x2A, struct termios2)
+const tcgets2 = 2<<30 | uintptr(unsafe.Sizeof(termios2{}))<<16 | 'T'<<8 | 0x2A
+
+func TestUARTSetBaud(t *testing.T) {
+	_, uart := openPTYUART(t)
+
+	for _, baud := range []int{UART_BAUD_115200, 250000, UART_BAUD_9600} {
+		err := uart.SetBaud(baud)
+		if err != nil {
+			t.Fatalf("SetBaud(%d): %s", baud, err)
+		}
+		if uart.Baud() != baud {
+			t.Errorf("Baud is %d instead of %d", uart.Baud(), baud)
+		}
+		var tio termios2
+		err = uart.ioctlPtr(tcgets2, unsafe.Pointer(&tio))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tio.ispeed != uint32(baud) || tio.ospeed != uint32(baud) {
+			t.Errorf("termios2 speeds are %d/%d instead of %d", tio.ispeed, tio.ospeed, baud)
+		}
+	}
+	if uart.SetBaud(0) == nil {
+		t.Error("expected error for baud rate 0")
+	}
+	if d := uart.ByteDuration(); d != time.Second*10/9600 {
+		t.Errorf("ByteDuration at 9600 8N1 is %s", d)
+	}
+
+	err := uart.SetFrameFormat(UART_BYTESIZE_7, UART_PARITY_EVEN, UART_STOPBITS_2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := uart.ByteDuration(); d != time.Second*11/9600 {
+		t.Errorf("ByteDuration at 9600 7E2 is %s", d)
+	}
+	if uart.SetFrameFormat(9, UART_PARITY_NONE, UART_STOPBITS_1) == nil {
+		t.Error("expected error for byte size 9")
+	}
+}